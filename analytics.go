@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Rollup periods.
+const (
+	PeriodHour = "hour"
+	PeriodDay  = "day"
+)
+
+// Hourly rollups are kept for a month and daily ones for a little over a
+// year; older rollups are dropped when the analytics are saved. The most
+// recent executions are kept for the analytics page.
+const (
+	analyticsHourlyRetention  = 31 * 24 * time.Hour
+	analyticsDailyRetention   = 400 * 24 * time.Hour
+	analyticsRecentExecutions = 20
+)
+
+// analyticsHourlyRange is the longest query answered from hourly rollups;
+// longer ones use daily rollups.
+const analyticsHourlyRange = 48 * time.Hour
+
+// Rollup aggregates the executions of a workflow that ended at the same
+// step within one hour or day, starting at Start (UTC). DurationMS is the
+// total duration of the executions.
+type Rollup struct {
+	Period     string    `json:"period"`
+	Start      time.Time `json:"start"`
+	Workflow   string    `json:"workflow"`
+	EndStep    string    `json:"end_step"`
+	Completed  int       `json:"completed"`
+	Failed     int       `json:"failed"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+type rollupKey struct {
+	period   string
+	start    int64
+	workflow string
+	endStep  string
+}
+
+// ExecutionSummary describes a finished workflow instance.
+type ExecutionSummary struct {
+	ID         string    `json:"id"`
+	Workflow   string    `json:"workflow"`
+	Status     string    `json:"status"`
+	EndStep    string    `json:"end_step"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// Analytics implements EventHandler and FailureHandler to aggregate
+// finished executions into hourly and daily rollups per workflow and end
+// step, and into daily funnels of the steps and transitions they took.
+// Rollups are kept in memory and saved to a JSON file by Save; an empty
+// path keeps them in memory only.
+type Analytics struct {
+	path    string
+	rollups map[rollupKey]*Rollup
+	funnels map[funnelKey]*FunnelRollup
+	recent  []ExecutionSummary
+	dirty   bool
+	mu      sync.Mutex
+}
+
+// analyticsFile is the JSON file the analytics are saved to.
+type analyticsFile struct {
+	Rollups []Rollup           `json:"rollups"`
+	Funnels []FunnelRollup     `json:"funnels"`
+	Recent  []ExecutionSummary `json:"recent"`
+}
+
+// NewAnalytics creates an analytics handler, loading the rollups saved in
+// path if it exists.
+func NewAnalytics(path string) (*Analytics, error) {
+	a := &Analytics{path: path, rollups: make(map[rollupKey]*Rollup), funnels: make(map[funnelKey]*FunnelRollup)}
+	if path == "" {
+		return a, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read analytics file: %w", err)
+	}
+
+	var file analyticsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid analytics file %s: %w", path, err)
+	}
+	for _, r := range file.Rollups {
+		rollup := r
+		a.rollups[rollup.key()] = &rollup
+	}
+	for _, f := range file.Funnels {
+		funnel := f
+		a.funnels[funnel.key()] = &funnel
+	}
+	a.recent = file.Recent
+	return a, nil
+}
+
+func (r *Rollup) key() rollupKey {
+	return rollupKey{period: r.Period, start: r.Start.Unix(), workflow: r.Workflow, endStep: r.EndStep}
+}
+
+// OnWorkflowStart does nothing; executions are counted when they finish
+func (a *Analytics) OnWorkflowStart(ctx context.Context, workflowName string, state *WorkflowState) error {
+	return nil
+}
+
+// OnWorkflowEnd records a completed execution
+func (a *Analytics) OnWorkflowEnd(ctx context.Context, workflowName string, state *WorkflowState) error {
+	a.record(workflowName, StatusCompleted, state)
+	return nil
+}
+
+// OnStepTransition does nothing
+func (a *Analytics) OnStepTransition(ctx context.Context, workflowName string, fromStep, toStep string, state *WorkflowState) error {
+	return nil
+}
+
+// OnWorkflowFailed records a failed execution
+func (a *Analytics) OnWorkflowFailed(ctx context.Context, workflowName string, state *WorkflowState, err error) error {
+	a.record(workflowName, StatusFailed, state)
+	return nil
+}
+
+// record adds an execution to its hourly and daily rollups.
+func (a *Analytics) record(workflowName, status string, state *WorkflowState) {
+	finished := state.FinishedAt
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+	finished = finished.UTC()
+
+	summary := ExecutionSummary{
+		ID:         state.ID,
+		Workflow:   workflowName,
+		Status:     status,
+		EndStep:    state.CurrentStep,
+		Error:      state.Error,
+		StartedAt:  state.StartedAt,
+		FinishedAt: finished,
+	}
+	if !state.StartedAt.IsZero() {
+		summary.DurationMS = float64(finished.Sub(state.StartedAt)) / float64(time.Millisecond)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, period := range []string{PeriodHour, PeriodDay} {
+		rollup := a.rollup(period, periodStart(period, finished), workflowName, summary.EndStep)
+		if status == StatusCompleted {
+			rollup.Completed++
+		} else {
+			rollup.Failed++
+		}
+		rollup.DurationMS += summary.DurationMS
+	}
+	a.recordFunnel(workflowName, finished, state)
+
+	a.recent = append(a.recent, summary)
+	if len(a.recent) > analyticsRecentExecutions {
+		a.recent = a.recent[len(a.recent)-analyticsRecentExecutions:]
+	}
+	a.dirty = true
+}
+
+// rollup returns the rollup for a key, creating it if needed. The caller
+// must hold the lock.
+func (a *Analytics) rollup(period string, start time.Time, workflowName, endStep string) *Rollup {
+	key := rollupKey{period: period, start: start.Unix(), workflow: workflowName, endStep: endStep}
+	rollup, ok := a.rollups[key]
+	if !ok {
+		rollup = &Rollup{Period: period, Start: start, Workflow: workflowName, EndStep: endStep}
+		a.rollups[key] = rollup
+	}
+	return rollup
+}
+
+// periodStart returns the start of the hour or day containing t, in UTC.
+func periodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	if period == PeriodHour {
+		return t.Truncate(time.Hour)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// periodLength returns the length of an hourly or daily period.
+func periodLength(period string) time.Duration {
+	if period == PeriodHour {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Save drops rollups past their retention and writes the analytics to the
+// file, if anything changed since the last save.
+func (a *Analytics) Save() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.path == "" || !a.dirty {
+		return nil
+	}
+
+	now := time.Now()
+	file := analyticsFile{Rollups: make([]Rollup, 0, len(a.rollups)), Recent: a.recent}
+	for key, rollup := range a.rollups {
+		retention := analyticsDailyRetention
+		if rollup.Period == PeriodHour {
+			retention = analyticsHourlyRetention
+		}
+		if now.Sub(rollup.Start) > retention {
+			delete(a.rollups, key)
+			continue
+		}
+		file.Rollups = append(file.Rollups, *rollup)
+	}
+	sort.Slice(file.Rollups, func(i, j int) bool {
+		ri, rj := file.Rollups[i], file.Rollups[j]
+		if !ri.Start.Equal(rj.Start) {
+			return ri.Start.Before(rj.Start)
+		}
+		if ri.Period != rj.Period {
+			return ri.Period < rj.Period
+		}
+		if ri.Workflow != rj.Workflow {
+			return ri.Workflow < rj.Workflow
+		}
+		return ri.EndStep < rj.EndStep
+	})
+
+	file.Funnels = make([]FunnelRollup, 0, len(a.funnels))
+	for key, funnel := range a.funnels {
+		if now.Sub(funnel.Day) > analyticsDailyRetention {
+			delete(a.funnels, key)
+			continue
+		}
+		file.Funnels = append(file.Funnels, *funnel)
+	}
+	sort.Slice(file.Funnels, func(i, j int) bool {
+		fi, fj := file.Funnels[i], file.Funnels[j]
+		if !fi.Day.Equal(fj.Day) {
+			return fi.Day.Before(fj.Day)
+		}
+		return fi.Workflow < fj.Workflow
+	})
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("failed to marshal analytics: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves half a file.
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write analytics file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write analytics file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write analytics file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write analytics file: %w", err)
+	}
+
+	a.dirty = false
+	return nil
+}
+
+// Run saves the analytics at every interval until the context is done, then
+// saves them a last time. Errors are logged.
+func (a *Analytics) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := a.Save(); err != nil {
+				slog.Error("failed to save analytics", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := a.Save(); err != nil {
+				slog.Error("failed to save analytics", "error", err)
+			}
+		}
+	}
+}
+
+// AnalyticsReport summarizes the executions that finished between From and
+// To. Rates are fractions between 0 and 1. Series has one point per hour
+// for ranges up to two days and one per day otherwise.
+type AnalyticsReport struct {
+	From          time.Time          `json:"from"`
+	To            time.Time          `json:"to"`
+	Workflow      string             `json:"workflow,omitempty"`
+	Period        string             `json:"period"`
+	Total         int                `json:"total"`
+	Completed     int                `json:"completed"`
+	Failed        int                `json:"failed"`
+	SuccessRate   float64            `json:"success_rate"`
+	FailureRate   float64            `json:"failure_rate"`
+	AvgDurationMS float64            `json:"avg_duration_ms"`
+	Series        []AnalyticsPoint   `json:"series"`
+	TopWorkflows  []WorkflowStats    `json:"top_workflows"`
+	EndSteps      []EndStepStats     `json:"end_steps"`
+	Recent        []ExecutionSummary `json:"recent"`
+}
+
+// AnalyticsPoint counts the executions that finished in one period.
+type AnalyticsPoint struct {
+	Start     time.Time `json:"start"`
+	Total     int       `json:"total"`
+	Completed int       `json:"completed"`
+	Failed    int       `json:"failed"`
+}
+
+// WorkflowStats counts the executions of one workflow.
+type WorkflowStats struct {
+	Workflow      string  `json:"workflow"`
+	Total         int     `json:"total"`
+	Completed     int     `json:"completed"`
+	Failed        int     `json:"failed"`
+	AvgDurationMS float64 `json:"avg_duration_ms"`
+}
+
+// EndStepStats counts the executions of a workflow that ended at a step.
+type EndStepStats struct {
+	Workflow  string `json:"workflow"`
+	EndStep   string `json:"end_step"`
+	Completed int    `json:"completed"`
+	Failed    int    `json:"failed"`
+}
+
+// Query reports the executions that finished between from and to, of one
+// workflow or, if workflow is empty, of all of them. The range is widened
+// to whole hours or days, depending on the rollups used.
+func (a *Analytics) Query(from, to time.Time, workflow string) *AnalyticsReport {
+	period := PeriodDay
+	if to.Sub(from) <= analyticsHourlyRange && time.Since(from) <= analyticsHourlyRetention {
+		period = PeriodHour
+	}
+	from = periodStart(period, from)
+	if start := periodStart(period, to); start.Before(to) {
+		to = start.Add(periodLength(period))
+	}
+
+	report := &AnalyticsReport{From: from, To: to.UTC(), Workflow: workflow, Period: period, Series: []AnalyticsPoint{}}
+
+	// One point per period, including periods without executions.
+	points := make(map[int64]*AnalyticsPoint)
+	for t := from; t.Before(to); t = t.Add(periodLength(period)) {
+		report.Series = append(report.Series, AnalyticsPoint{Start: t})
+	}
+	for i := range report.Series {
+		points[report.Series[i].Start.Unix()] = &report.Series[i]
+	}
+
+	workflows := make(map[string]*WorkflowStats)
+	endSteps := make(map[[2]string]*EndStepStats)
+	var duration float64
+
+	a.mu.Lock()
+	for _, rollup := range a.rollups {
+		if rollup.Period != period || rollup.Start.Before(from) || !rollup.Start.Before(to) {
+			continue
+		}
+		if workflow != "" && rollup.Workflow != workflow {
+			continue
+		}
+		total := rollup.Completed + rollup.Failed
+
+		report.Completed += rollup.Completed
+		report.Failed += rollup.Failed
+		duration += rollup.DurationMS
+
+		if point, ok := points[rollup.Start.Unix()]; ok {
+			point.Total += total
+			point.Completed += rollup.Completed
+			point.Failed += rollup.Failed
+		}
+
+		stats, ok := workflows[rollup.Workflow]
+		if !ok {
+			stats = &WorkflowStats{Workflow: rollup.Workflow}
+			workflows[rollup.Workflow] = stats
+		}
+		stats.Total += total
+		stats.Completed += rollup.Completed
+		stats.Failed += rollup.Failed
+		stats.AvgDurationMS += rollup.DurationMS
+
+		stepKey := [2]string{rollup.Workflow, rollup.EndStep}
+		step, ok := endSteps[stepKey]
+		if !ok {
+			step = &EndStepStats{Workflow: rollup.Workflow, EndStep: rollup.EndStep}
+			endSteps[stepKey] = step
+		}
+		step.Completed += rollup.Completed
+		step.Failed += rollup.Failed
+	}
+
+	report.Recent = []ExecutionSummary{}
+	for i := len(a.recent) - 1; i >= 0; i-- {
+		summary := a.recent[i]
+		if (workflow == "" || summary.Workflow == workflow) &&
+			!summary.FinishedAt.Before(from) && summary.FinishedAt.Before(to) {
+			report.Recent = append(report.Recent, summary)
+		}
+	}
+	a.mu.Unlock()
+
+	report.Total = report.Completed + report.Failed
+	if report.Total > 0 {
+		report.SuccessRate = float64(report.Completed) / float64(report.Total)
+		report.FailureRate = float64(report.Failed) / float64(report.Total)
+		report.AvgDurationMS = duration / float64(report.Total)
+	}
+
+	report.TopWorkflows = make([]WorkflowStats, 0, len(workflows))
+	for _, stats := range workflows {
+		if stats.Total > 0 {
+			stats.AvgDurationMS /= float64(stats.Total)
+		}
+		report.TopWorkflows = append(report.TopWorkflows, *stats)
+	}
+	sort.Slice(report.TopWorkflows, func(i, j int) bool {
+		wi, wj := report.TopWorkflows[i], report.TopWorkflows[j]
+		if wi.Total != wj.Total {
+			return wi.Total > wj.Total
+		}
+		return wi.Workflow < wj.Workflow
+	})
+
+	report.EndSteps = make([]EndStepStats, 0, len(endSteps))
+	for _, step := range endSteps {
+		report.EndSteps = append(report.EndSteps, *step)
+	}
+	sort.Slice(report.EndSteps, func(i, j int) bool {
+		si, sj := report.EndSteps[i], report.EndSteps[j]
+		if si.Workflow != sj.Workflow {
+			return si.Workflow < sj.Workflow
+		}
+		if ti, tj := si.Completed+si.Failed, sj.Completed+sj.Failed; ti != tj {
+			return ti > tj
+		}
+		return si.EndStep < sj.EndStep
+	})
+
+	return report
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultBatchConcurrency is the number of rows run at once when no limit
+// is given.
+const defaultBatchConcurrency = 4
+
+// Column types for batch input. ColumnAuto infers the type from the values
+// in the column.
+const (
+	ColumnAuto    = "auto"
+	ColumnString  = "string"
+	ColumnNumber  = "number"
+	ColumnInteger = "integer"
+	ColumnBoolean = "boolean"
+)
+
+// BatchStatusCancelled is the status of the rows a cancelled batch didn't
+// get to run.
+const BatchStatusCancelled = "cancelled"
+
+// BatchInput is a table of workflow data read from CSV.
+type BatchInput struct {
+	Columns []string
+	Rows    []map[string]any
+}
+
+// BatchResult is the outcome of running one row of a batch.
+type BatchResult struct {
+	Row       int            `json:"row"`
+	Data      map[string]any `json:"data"`
+	Status    string         `json:"status"`
+	FinalStep string         `json:"final_step"`
+	Path      []string       `json:"path"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// ParseColumnTypes parses a column type list such as "age=integer,zip=string".
+func ParseColumnTypes(spec string) (map[string]string, error) {
+	types := make(map[string]string)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		column, columnType, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid column type '%s', expected column=type", part)
+		}
+		types[strings.TrimSpace(column)] = strings.TrimSpace(columnType)
+	}
+	return types, nil
+}
+
+// ReadBatchCSV reads workflow data from CSV with a header row. Each column is
+// converted to the type given in types; columns without a type are inferred:
+// a column whose values all parse as numbers holds numbers, one whose values
+// are all true or false holds booleans, and anything else holds strings.
+// Empty cells are left out of the row's data.
+func ReadBatchCSV(r io.Reader, types map[string]string) (*BatchInput, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("CSV has no header row")
+	}
+
+	input := &BatchInput{Columns: records[0]}
+	records = records[1:]
+
+	columnTypes := make([]string, len(input.Columns))
+	for i, column := range input.Columns {
+		columnType := types[column]
+		switch columnType {
+		case "", ColumnAuto:
+			columnType = inferColumnType(records, i)
+		case ColumnString, ColumnNumber, ColumnInteger, ColumnBoolean:
+		default:
+			return nil, fmt.Errorf("column '%s': unknown type '%s'", column, columnType)
+		}
+		columnTypes[i] = columnType
+	}
+
+	for line, record := range records {
+		row := make(map[string]any, len(record))
+		for i, cell := range record {
+			if cell == "" {
+				continue
+			}
+			value, err := convertCell(cell, columnTypes[i])
+			if err != nil {
+				return nil, fmt.Errorf("row %d, column '%s': %w", line+1, input.Columns[i], err)
+			}
+			row[input.Columns[i]] = value
+		}
+		input.Rows = append(input.Rows, row)
+	}
+
+	return input, nil
+}
+
+func inferColumnType(records [][]string, column int) string {
+	numbers, booleans, values := 0, 0, 0
+	for _, record := range records {
+		cell := record[column]
+		if cell == "" {
+			continue
+		}
+		values++
+		if _, err := strconv.ParseFloat(cell, 64); err == nil {
+			numbers++
+		}
+		if cell == "true" || cell == "false" {
+			booleans++
+		}
+	}
+
+	switch {
+	case values == 0:
+		return ColumnString
+	case numbers == values:
+		return ColumnNumber
+	case booleans == values:
+		return ColumnBoolean
+	default:
+		return ColumnString
+	}
+}
+
+func convertCell(cell, columnType string) (any, error) {
+	switch columnType {
+	case ColumnNumber:
+		n, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", cell)
+		}
+		return n, nil
+	case ColumnInteger:
+		n, err := strconv.ParseInt(cell, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not an integer", cell)
+		}
+		return float64(n), nil
+	case ColumnBoolean:
+		b, err := strconv.ParseBool(cell)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a boolean", cell)
+		}
+		return b, nil
+	default:
+		return cell, nil
+	}
+}
+
+// RunBatch runs a new instance of a workflow for each row, with at most
+// concurrency instances running at once. Rows that fail are reported in
+// their result rather than stopping the batch. Results are in row order.
+// If ctx is cancelled, the rows not yet started are reported as cancelled
+// and ctx's error is returned with the results.
+func (e *WorkflowEngine) RunBatch(ctx context.Context, wfName string, rows []map[string]any, concurrency int) ([]BatchResult, error) {
+	if _, ok := e.Workflow(wfName); !ok {
+		return nil, fmt.Errorf("workflow '%s' not found", wfName)
+	}
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	results := make([]BatchResult, len(rows))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// A row handed over as the batch is cancelled is left
+				// to be reported as cancelled.
+				if ctx.Err() == nil {
+					results[i] = e.runBatchRow(ctx, wfName, i, rows[i])
+				}
+			}
+		}()
+	}
+
+	for i := range rows {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i := range results {
+		if results[i].Row == 0 {
+			results[i] = BatchResult{
+				Row:    i + 1,
+				Data:   CopyData(rows[i]),
+				Status: BatchStatusCancelled,
+				Error:  ctx.Err().Error(),
+			}
+		}
+	}
+	return results, ctx.Err()
+}
+
+func (e *WorkflowEngine) runBatchRow(ctx context.Context, wfName string, index int, row map[string]any) BatchResult {
+	state := &WorkflowState{Data: CopyData(row)}
+	err := e.RunWorkflow(ctx, wfName, state)
+
+	result := BatchResult{
+		Row:       index + 1,
+		Data:      state.Data,
+		Status:    state.Status,
+		FinalStep: state.CurrentStep,
+		Path:      state.Path,
+		Error:     state.Error,
+	}
+	if err != nil {
+		result.Status = StatusFailed
+		if result.Error == "" {
+			result.Error = err.Error()
+		}
+	}
+	return result
+}
+
+// WriteBatchResultsCSV writes results as CSV: the given input columns
+// followed by status, final_step, path and error. Steps in the path are
+// separated by " > ".
+func WriteBatchResultsCSV(w io.Writer, columns []string, results []BatchResult) error {
+	writer := csv.NewWriter(w)
+	header := append(append([]string{"row"}, columns...), "status", "final_step", "path", "error")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		record := []string{strconv.Itoa(result.Row)}
+		for _, column := range columns {
+			record = append(record, formatCSVValue(result.Data[column]))
+		}
+		record = append(record, result.Status, result.FinalStep, strings.Join(result.Path, " > "), result.Error)
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteBatchResultsJSON writes results as a JSON array.
+func WriteBatchResultsJSON(w io.Writer, results []BatchResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+func formatCSVValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprintf("%v", val)
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunBatchCancelled(t *testing.T) {
+	// cancel cancels the batch being run by the current test case.
+	var cancel context.CancelFunc
+	registry := NewRegistry()
+	registry.RegisterRule("stop", func(ctx context.Context, data map[string]any) (bool, error) {
+		if data["stop"] == true {
+			cancel()
+			return false, errors.New("stopped")
+		}
+		return true, nil
+	})
+	workflow := `
+name: Batch
+start_step: start
+transitions:
+  - from: start
+    to: done
+    rule: stop
+    fallback_to: done
+`
+	engine, err := newTestEngine(t, EngineOptions{Registry: registry}, map[string]string{"batch.yml": workflow}, nil)
+	if err != nil {
+		t.Fatalf("NewWorkflowEngine: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		cancelFirst  bool
+		stopRow      int
+		wantStatuses []string
+	}{
+		{
+			name:         "not cancelled",
+			stopRow:      -1,
+			wantStatuses: []string{StatusCompleted, StatusCompleted, StatusCompleted, StatusCompleted},
+		},
+		{
+			name:         "cancelled while running",
+			stopRow:      1,
+			wantStatuses: []string{StatusCompleted, StatusFailed, BatchStatusCancelled, BatchStatusCancelled},
+		},
+		{
+			name:         "cancelled before starting",
+			cancelFirst:  true,
+			stopRow:      -1,
+			wantStatuses: []string{BatchStatusCancelled, BatchStatusCancelled, BatchStatusCancelled, BatchStatusCancelled},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelFirst {
+				cancel()
+			}
+			rows := make([]map[string]any, len(tt.wantStatuses))
+			for i := range rows {
+				rows[i] = map[string]any{"id": i, "stop": i == tt.stopRow}
+			}
+
+			results, err := engine.RunBatch(ctx, "Batch", rows, 1)
+			cancelled := tt.wantStatuses[len(tt.wantStatuses)-1] == BatchStatusCancelled
+			if cancelled && !errors.Is(err, context.Canceled) {
+				t.Errorf("err = %v, want context.Canceled", err)
+			}
+			if !cancelled && err != nil {
+				t.Errorf("RunBatch: %v", err)
+			}
+			if len(results) != len(rows) {
+				t.Fatalf("got %d results, want %d", len(results), len(rows))
+			}
+
+			for i, result := range results {
+				if result.Row != i+1 || result.Status != tt.wantStatuses[i] || result.Data["id"] != i {
+					t.Errorf("result %d = %+v, want row %d %s", i, result, i+1, tt.wantStatuses[i])
+				}
+				if result.Status == BatchStatusCancelled && (result.Error != context.Canceled.Error() || result.FinalStep != "" || result.Path != nil) {
+					t.Errorf("cancelled result %d = %+v", i, result)
+				}
+			}
+		})
+	}
+}
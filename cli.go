@@ -0,0 +1,614 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigPath is the configuration file commands read unless -config
+// is given.
+const defaultConfigPath = "config.yaml"
+
+// cliCommand is a subcommand of the myworkflow binary.
+type cliCommand struct {
+	run     func(args []string) error
+	summary string
+}
+
+var cliCommands map[string]cliCommand
+
+func init() {
+	cliCommands = map[string]cliCommand{
+		"serve":    {serveCommand, "start the web interface and API"},
+		"run":      {runCommand, "run a workflow once: run <workflow> -data file.json"},
+		"validate": {validateCommand, "check workflow files: validate [file.yml ...]"},
+		"list":     {listCommand, "list workflows and rules: list [workflows|rules]"},
+		"test":     {testCommand, "run workflow test cases: test [file or dir ...]"},
+		"batch":    {batchCommand, "run a workflow over each row of a CSV file"},
+		"ingest":   {ingestCommand, "run JSONL execution requests"},
+		"replay":   {replayCommand, "compare a candidate workflow against a request log"},
+		"export":   {exportCommand, "print a workflow as json, yaml or dot: export <workflow>"},
+	}
+}
+
+// runCLI runs the subcommand named by the first argument. Without arguments
+// it starts the server.
+func runCLI(args []string) error {
+	if len(args) == 0 {
+		err := serveCommand(nil)
+		shutdownTracer()
+		return err
+	}
+
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		printUsage(os.Stdout)
+		return nil
+	}
+
+	command, ok := cliCommands[name]
+	if !ok {
+		printUsage(os.Stderr)
+		return fmt.Errorf("unknown command '%s'", name)
+	}
+	err := command.run(args[1:])
+	shutdownTracer()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// shutdownTracer exports the spans recorded by a command before it exits.
+func shutdownTracer() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		slog.Warn("failed to shut down tracer", "error", err)
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: myworkflow <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range sortedKeys(cliCommands) {
+		fmt.Fprintf(w, "  %-9s %s\n", name, cliCommands[name].summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every command accepts -config to choose the configuration file.")
+}
+
+// configFlags holds the configuration flags shared by every command: -config
+// and a flag for each configuration key, such as -listen-addr.
+type configFlags struct {
+	path      string
+	overrides [][2]string
+	// file is the configuration as read from the file, before overrides.
+	file *Config
+}
+
+// newFlagSet creates the flags for a command, including the shared
+// configuration flags.
+func newFlagSet(name string) (*flag.FlagSet, *configFlags) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	cf := &configFlags{}
+	flags.StringVar(&cf.path, "config", defaultConfigPath, "configuration file (.yaml, .json or key=value)")
+
+	defaults := DefaultConfig()
+	for _, key := range ConfigKeys() {
+		value, _ := defaults.Get(key)
+		flags.Func(strings.ReplaceAll(key, "_", "-"), fmt.Sprintf("override %s (default %q)", key, value), func(value string) error {
+			// Catch invalid values while parsing flags.
+			if err := DefaultConfig().Set(key, value); err != nil {
+				return err
+			}
+			cf.overrides = append(cf.overrides, [2]string{key, value})
+			return nil
+		})
+	}
+	return flags, cf
+}
+
+// load reads the configuration file and applies MYWORKFLOW_* environment
+// variables and then flags on top of it. If the default file does not exist
+// the defaults are used.
+func (cf *configFlags) load() (*Config, error) {
+	cfg := DefaultConfig()
+	if cf.path != defaultConfigPath || configFileExists(cf.path) {
+		loaded, err := LoadConfig(cf.path)
+		if err != nil {
+			return nil, err
+		}
+		cfg = loaded
+	}
+	file := *cfg
+	cf.file = &file
+
+	if err := cfg.ApplyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	for _, override := range cf.overrides {
+		if err := cfg.Set(override[0], override[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// setup loads the configuration and initializes the engine from it
+func (cf *configFlags) setup() (*Config, error) {
+	cfg, err := cf.load()
+	if err != nil {
+		return nil, err
+	}
+	if err := initEngine(cfg); err != nil {
+		return nil, fmt.Errorf("failed to initialize engine: %w", err)
+	}
+	return cfg, nil
+}
+
+// parseWithPositional parses flags that may come before or after the
+// command's positional arguments, as in "run CustomerOnboarding -data x.json".
+func parseWithPositional(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	flags.Parse(args)
+	return append(positional, flags.Args()...)
+}
+
+// serveCommand starts the web interface and API:
+//
+//	myworkflow serve -listen-addr :9090
+func serveCommand(args []string) error {
+	flags, cf := newFlagSet("serve")
+	flags.Parse(args)
+
+	cfg, err := cf.setup()
+	if err != nil {
+		return err
+	}
+	settings = NewSettings(cf.path, cf.file, cfg, applySettings)
+	return serve(cfg)
+}
+
+// runCommand runs one instance of a workflow and prints its final state as
+// JSON. It fails if the instance does not complete:
+//
+//	myworkflow run CustomerOnboarding -data customer.json
+func runCommand(args []string) error {
+	flags, cf := newFlagSet("run")
+	dataPath := flags.String("data", "", "JSON file with the instance data, or - for stdin")
+	positional := parseWithPositional(flags, args)
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: run <workflow> -data file.json")
+	}
+
+	var data map[string]any
+	if *dataPath != "" {
+		in, closeIn, err := openInput(*dataPath)
+		if err != nil {
+			return err
+		}
+		defer closeIn()
+		if err := json.NewDecoder(in).Decode(&data); err != nil {
+			return fmt.Errorf("invalid data: %w", err)
+		}
+	}
+
+	if _, err := cf.setup(); err != nil {
+		return err
+	}
+
+	state := &WorkflowState{Data: data}
+	runErr := engine.RunWorkflow(context.Background(), positional[0], state)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(state); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// validateCommand checks workflow files without loading them into a running
+// engine. With no arguments it checks every workflow in the workflows
+// directory:
+//
+//	myworkflow validate workflows/customer_onboarding.yml
+func validateCommand(args []string) error {
+	flags, cf := newFlagSet("validate")
+	paths := parseWithPositional(flags, args)
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+
+	if len(paths) == 0 {
+		files, err := workflowFiles(cfg.WorkflowsDir)
+		if err != nil {
+			return err
+		}
+		paths = files
+	}
+
+	// An engine without workflows, so that one invalid file doesn't prevent
+	// the others from being checked.
+	checker, err := NewWorkflowEngine(EngineOptions{
+		RulesDir:    cfg.RulesDir,
+		LuaPoolSize: cfg.LuaPoolSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize engine: %w", err)
+	}
+
+	invalid := 0
+	for _, path := range paths {
+		wf, err := checker.loadWorkflowFromFile(path)
+		if err == nil {
+			err = checker.validateWorkflow(wf)
+		}
+		if err != nil {
+			invalid++
+			fmt.Printf("FAIL %s: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("ok   %s (%s)\n", path, wf.Name)
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d workflows are invalid", invalid, len(paths))
+	}
+	return nil
+}
+
+// listCommand prints the loaded workflows and the available rules
+func listCommand(args []string) error {
+	flags, cf := newFlagSet("list")
+	positional := parseWithPositional(flags, args)
+
+	what := "all"
+	if len(positional) > 0 {
+		what = positional[0]
+	}
+	if what != "all" && what != "workflows" && what != "rules" {
+		return fmt.Errorf("usage: list [workflows|rules]")
+	}
+
+	if _, err := cf.setup(); err != nil {
+		return err
+	}
+
+	if what != "rules" {
+		fmt.Println("Workflows:")
+		for _, name := range engine.WorkflowNames() {
+			wf, _ := engine.Workflow(name)
+			fmt.Printf("  %-30s %s\n", name, wf.Description)
+		}
+	}
+
+	if what != "workflows" {
+		rules, err := ruleStorage.ListRules(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, name := range engine.Registry().RuleNames() {
+			rules = append(rules, Rule{Name: name, Language: LanguageGo})
+		}
+		sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+
+		fmt.Println("Rules:")
+		for _, rule := range rules {
+			fmt.Printf("  %-30s %s\n", rule.Name, rule.Language)
+		}
+	}
+	return nil
+}
+
+// testCommand runs workflow test case files, by default those in the tests
+// directory, and fails if any case fails:
+//
+//	myworkflow test tests/customer_onboarding.test.yaml
+func testCommand(args []string) error {
+	flags, cf := newFlagSet("test")
+	verbose := flags.Bool("v", false, "list passing cases too")
+	paths := parseWithPositional(flags, args)
+	if len(paths) == 0 {
+		paths = []string{"tests"}
+	}
+
+	files, err := FindWorkflowTestFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no test files found in %s", strings.Join(paths, ", "))
+	}
+
+	if _, err := cf.setup(); err != nil {
+		return err
+	}
+
+	total, failed := 0, 0
+	for _, path := range files {
+		file, err := LoadWorkflowTestFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, result := range engine.RunWorkflowTests(context.Background(), path, file) {
+			total++
+			if result.Passed() {
+				if *verbose {
+					fmt.Printf("PASS %s: %s\n", result.Workflow, result.Case)
+				}
+				continue
+			}
+			failed++
+			fmt.Printf("FAIL %s: %s (%s)\n", result.Workflow, result.Case, result.File)
+			for _, failure := range result.Failures {
+				fmt.Printf("     %s\n", failure)
+			}
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", total-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d test cases failed", failed, total)
+	}
+	return nil
+}
+
+// exportCommand prints a loaded workflow definition as JSON, YAML or a
+// Graphviz dot graph:
+//
+//	myworkflow export CustomerOnboarding -format dot | dot -Tsvg > onboarding.svg
+func exportCommand(args []string) error {
+	flags, cf := newFlagSet("export")
+	format := flags.String("format", "yaml", "output format: json, yaml or dot")
+	positional := parseWithPositional(flags, args)
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: export <workflow> [-format json|yaml|dot]")
+	}
+
+	if _, err := cf.setup(); err != nil {
+		return err
+	}
+
+	wf, ok := engine.Workflow(positional[0])
+	if !ok {
+		return fmt.Errorf("workflow '%s' not found", positional[0])
+	}
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(wf)
+	case "yaml":
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(wf)
+	case "dot":
+		_, err := io.WriteString(os.Stdout, WorkflowDot(wf))
+		return err
+	default:
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
+}
+
+// WorkflowDot renders a workflow as a Graphviz graph. Each transition is an
+// edge labelled with its rule, and its fallback a dashed edge.
+func WorkflowDot(wf Workflow) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", wf.Name)
+	b.WriteString("  rankdir=LR;\n")
+	fmt.Fprintf(&b, "  %q [shape=doublecircle];\n", wf.StartStep)
+
+	for _, t := range wf.Transitions {
+		fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", t.FromStep, t.ToStep, t.Rule.String())
+		if t.FallbackStep != "" && t.FallbackStep != t.ToStep {
+			fmt.Fprintf(&b, "  %q -> %q [label=%q, style=dashed];\n", t.FromStep, t.FallbackStep, "not "+t.Rule.String())
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// workflowFiles returns the YAML files in the workflows directory
+func workflowFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workflows directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && (strings.HasSuffix(entry.Name(), ".yaml") || strings.HasSuffix(entry.Name(), ".yml")) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// batchCommand runs a workflow over every row of a CSV file:
+//
+//	myworkflow batch -workflow CustomerOnboarding -input customers.csv -format json
+func batchCommand(args []string) error {
+	flags, cf := newFlagSet("batch")
+	workflowName := flags.String("workflow", "", "workflow to run (required)")
+	inputPath := flags.String("input", "-", "CSV input file, or - for stdin")
+	outputPath := flags.String("output", "-", "results file, or - for stdout")
+	format := flags.String("format", "csv", "results format: csv or json")
+	concurrency := flags.Int("concurrency", defaultBatchConcurrency, "number of rows to run at once")
+	typeSpec := flags.String("types", "", "column types, e.g. age=integer,zip=string")
+	flags.Parse(args)
+
+	if *workflowName == "" {
+		return fmt.Errorf("-workflow is required")
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
+	types, err := ParseColumnTypes(*typeSpec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := cf.setup(); err != nil {
+		return err
+	}
+
+	in, closeIn, err := openInput(*inputPath)
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+
+	input, err := ReadBatchCSV(in, types)
+	if err != nil {
+		return err
+	}
+
+	results, err := engine.RunBatch(context.Background(), *workflowName, input.Rows, *concurrency)
+	if err != nil {
+		return err
+	}
+
+	out, closeOut, err := createOutput(*outputPath)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	if *format == "json" {
+		return WriteBatchResultsJSON(out, results)
+	}
+	return WriteBatchResultsCSV(out, input.Columns, results)
+}
+
+// ingestCommand executes newline-delimited JSON execution requests and
+// writes one JSON result per line:
+//
+//	myworkflow ingest -input requests.jsonl -output results.jsonl
+func ingestCommand(args []string) error {
+	flags, cf := newFlagSet("ingest")
+	inputPath := flags.String("input", "-", "JSONL request file, or - for stdin")
+	outputPath := flags.String("output", "-", "JSONL results file, or - for stdout")
+	flags.Parse(args)
+
+	if _, err := cf.setup(); err != nil {
+		return err
+	}
+
+	in, closeIn, err := openInput(*inputPath)
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+
+	out, closeOut, err := createOutput(*outputPath)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	summary, err := engine.RunJSONL(context.Background(), in, out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Processed %d requests: %d completed, %d failed\n", summary.Total, summary.Completed, summary.Failed)
+	return nil
+}
+
+// replayCommand replays a captured request log against a candidate version
+// of a workflow and reports which requests would have a different outcome:
+//
+//	myworkflow replay -input requests.jsonl -workflow candidate.yml
+func replayCommand(args []string) error {
+	flags, cf := newFlagSet("replay")
+	inputPath := flags.String("input", "-", "JSONL request log, or - for stdin")
+	outputPath := flags.String("output", "-", "JSONL comparison file, or - for stdout")
+	candidatePath := flags.String("workflow", "", "candidate workflow file (required)")
+	changedOnly := flags.Bool("changed", false, "only write requests whose outcome changed")
+	runActions := flags.Bool("run-actions", false, "run step actions, repeating their side effects")
+	flags.Parse(args)
+
+	if *candidatePath == "" {
+		return fmt.Errorf("-workflow is required")
+	}
+
+	if _, err := cf.setup(); err != nil {
+		return err
+	}
+
+	candidate, err := engine.loadWorkflowFromFile(*candidatePath)
+	if err != nil {
+		return fmt.Errorf("failed to load candidate workflow: %w", err)
+	}
+
+	in, closeIn, err := openInput(*inputPath)
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+
+	out, closeOut, err := createOutput(*outputPath)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+
+	opts := ReplayOptions{ChangedOnly: *changedOnly, RunActions: *runActions}
+	summary, err := engine.Replay(context.Background(), in, *candidate, out, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Replayed %d requests: %d changed, %d skipped\n", summary.Total, summary.Changed, summary.Skipped)
+	return nil
+}
+
+// openInput opens a file for reading, or stdin for "-"
+func openInput(path string) (io.Reader, func(), error) {
+	if path == "-" {
+		return os.Stdin, func() {}, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
+
+// createOutput creates a file for writing, or stdout for "-"
+func createOutput(path string) (io.Writer, func(), error) {
+	if path == "-" {
+		return os.Stdout, func() {}, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule languages understood by the built-in rule engines.
+const (
+	LanguageGo            = "Go"
+	LanguageLua           = "Lua"
+	LanguageExpr          = "Expr"
+	LanguageDecisionTable = "DecisionTable"
+)
+
+// ruleLanguages lists the rule languages in order of precedence. When rules
+// of the same name exist in several languages, the composite rule engine
+// evaluates, and rule storage loads, the one whose language comes first.
+var ruleLanguages = []string{LanguageGo, LanguageExpr, LanguageDecisionTable, LanguageLua}
+
+// ruleLanguageRank returns the position of a language in ruleLanguages;
+// other languages rank after all of them.
+func ruleLanguageRank(language string) int {
+	for i, l := range ruleLanguages {
+		if strings.EqualFold(l, language) {
+			return i
+		}
+	}
+	return len(ruleLanguages)
+}
+
+// ErrRuleNotFound is returned when no rule engine has a rule.
+var ErrRuleNotFound = errors.New("rule not found")
+
+// CompositeRuleEngine implements the RuleEngine interface by dispatching each
+// rule to one of several single-language engines. A rule name with a file
+// extension ("is_over_18.lua") goes to the engine owning that extension;
+// otherwise the engines are asked in the order of ruleLanguages, followed by
+// engines for other languages in the order they were added, and the first
+// one that has the rule evaluates it.
+type CompositeRuleEngine struct {
+	engines []LanguageRuleEngine
+	mu      sync.RWMutex
+}
+
+// NewCompositeRuleEngine creates a dispatcher over the given engines
+func NewCompositeRuleEngine(engines ...LanguageRuleEngine) *CompositeRuleEngine {
+	c := &CompositeRuleEngine{}
+	for _, engine := range engines {
+		c.AddEngine(engine)
+	}
+	return c
+}
+
+// AddEngine adds an engine, replacing any engine for the same language
+func (c *CompositeRuleEngine) AddEngine(engine LanguageRuleEngine) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, existing := range c.engines {
+		if strings.EqualFold(existing.Language(), engine.Language()) {
+			c.engines[i] = engine
+			return
+		}
+	}
+
+	rank := ruleLanguageRank(engine.Language())
+	i := len(c.engines)
+	for i > 0 && ruleLanguageRank(c.engines[i-1].Language()) > rank {
+		i--
+	}
+	c.engines = slices.Insert(c.engines, i, engine)
+}
+
+// Engine returns the engine for a language
+func (c *CompositeRuleEngine) Engine(language string) (LanguageRuleEngine, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, engine := range c.engines {
+		if strings.EqualFold(engine.Language(), language) {
+			return engine, true
+		}
+	}
+	return nil, false
+}
+
+// Resolve returns the engine that evaluates a rule and the name to pass to it.
+// A rule with a language is only looked up in the engine for that language;
+// otherwise the engine is chosen from the name.
+func (c *CompositeRuleEngine) Resolve(rule Rule) (LanguageRuleEngine, string, error) {
+	if rule.Language != "" {
+		engine, ok := c.Engine(rule.Language)
+		if !ok {
+			return nil, "", fmt.Errorf("no rule engine for language '%s'", rule.Language)
+		}
+		if !engine.HasRule(rule.Name) {
+			return nil, "", fmt.Errorf("%w: %s (%s)", ErrRuleNotFound, rule.Name, engine.Language())
+		}
+		return engine, rule.Name, nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ruleName := rule.Name
+	for _, engine := range c.engines {
+		for _, ext := range engine.Extensions() {
+			name := strings.TrimSuffix(ruleName, ext)
+			if name != ruleName && name != "" && !IsInlineExpression(name) {
+				return engine, name, nil
+			}
+		}
+	}
+
+	for _, engine := range c.engines {
+		if engine.HasRule(ruleName) {
+			return engine, ruleName, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("%w: %s", ErrRuleNotFound, ruleName)
+}
+
+// HasRule reports whether any engine has the rule
+func (c *CompositeRuleEngine) HasRule(ruleName string) bool {
+	_, _, err := c.Resolve(Rule{Name: ruleName})
+	return err == nil
+}
+
+// Language returns the languages of all engines
+func (c *CompositeRuleEngine) Language() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	languages := make([]string, 0, len(c.engines))
+	for _, engine := range c.engines {
+		languages = append(languages, engine.Language())
+	}
+	return strings.Join(languages, ",")
+}
+
+// Evaluate dispatches the rule to the engine that has it.
+func (c *CompositeRuleEngine) Evaluate(ctx context.Context, ruleName string, data map[string]any) (bool, error) {
+	engine, name, err := c.Resolve(Rule{Name: ruleName})
+	if err != nil {
+		return false, err
+	}
+	return engine.Evaluate(ctx, name, data)
+}
+
+// EvaluateMutating dispatches a mutating rule to the engine that has it.
+func (c *CompositeRuleEngine) EvaluateMutating(ctx context.Context, ruleName string, data map[string]any) (bool, map[string]any, error) {
+	engine, name, err := c.Resolve(Rule{Name: ruleName})
+	if err != nil {
+		return false, nil, err
+	}
+
+	mutator, ok := engine.(MutatingRuleEngine)
+	if !ok {
+		return false, nil, fmt.Errorf("%s rules cannot modify workflow data", engine.Language())
+	}
+	return mutator.EvaluateMutating(ctx, name, data)
+}
+
+// RegisterRule offers the rule to each engine in turn and keeps the first
+// one that accepts it, so Go functions go to the Go engine and expression
+// source to the expression engine.
+func (c *CompositeRuleEngine) RegisterRule(name string, rule any) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var errs []string
+	for _, engine := range c.engines {
+		err := engine.RegisterRule(name, rule)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", engine.Language(), err))
+	}
+	return fmt.Errorf("no rule engine accepted rule '%s' (%s)", name, strings.Join(errs, "; "))
+}
+
+// Check type-checks a rule against a schema if its engine supports checking.
+func (c *CompositeRuleEngine) Check(ruleName string, schema ExprSchema) error {
+	engine, name, err := c.Resolve(Rule{Name: ruleName})
+	if err != nil {
+		return err
+	}
+	if checker, ok := engine.(RuleChecker); ok {
+		return checker.Check(name, schema)
+	}
+	return nil
+}
+
+// RunAction runs an action script with the first engine that can run
+// actions and has a script of that name.
+func (c *CompositeRuleEngine) RunAction(ctx context.Context, actionName string, state *WorkflowState) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, engine := range c.engines {
+		if runner, ok := engine.(ActionRunner); ok && engine.HasRule(actionName) {
+			return runner.RunAction(ctx, actionName, state)
+		}
+	}
+	return fmt.Errorf("action '%s' not found", actionName)
+}
+
+// SetCacheTTL sets the cache TTL of every engine that caches rule files.
+func (c *CompositeRuleEngine) SetCacheTTL(ttl time.Duration) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, engine := range c.engines {
+		if cache, ok := engine.(RuleCache); ok {
+			cache.SetCacheTTL(ttl)
+		}
+	}
+}
+
+// ruleCacheFresh reports whether a rule compiled from a file with the given
+// modification time can still be used: the file must be unchanged and, with
+// a non-zero TTL, the rule compiled less than ttl ago.
+func ruleCacheFresh(modTime, compiledAt time.Time, info os.FileInfo, ttl time.Duration) bool {
+	if !modTime.Equal(info.ModTime()) {
+		return false
+	}
+	return ttl <= 0 || time.Since(compiledAt) < ttl
+}
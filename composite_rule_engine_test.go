@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newLanguageTestEngine creates a composite rule engine with an engine for
+// every built-in language over the given rule files, adding the engines in
+// the reverse of their precedence.
+func newLanguageTestEngine(t *testing.T, rules map[string]string) (*CompositeRuleEngine, string) {
+	t.Helper()
+	dir := writeTestFiles(t, rules)
+	pool := NewLuaStatePool(1)
+	t.Cleanup(pool.Close)
+	registry := NewRegistry()
+	registry.RegisterRule("go_rule", func(ctx context.Context, data map[string]any) (bool, error) {
+		return true, nil
+	})
+	return NewCompositeRuleEngine(
+		NewLuaRuleEngine(pool, dir),
+		NewDecisionTableRuleEngine(dir),
+		NewExprRuleEngine(dir),
+		NewGoRuleEngine(registry),
+	), dir
+}
+
+func TestRuleLanguagePrecedence(t *testing.T) {
+	for i := 1; i < len(ruleFileExtensions); i++ {
+		if ruleLanguageRank(ruleFileExtensions[i-1].language) > ruleLanguageRank(ruleFileExtensions[i].language) {
+			t.Errorf("rule file extension %s is listed before %s", ruleFileExtensions[i-1].extension, ruleFileExtensions[i].extension)
+		}
+	}
+
+	const (
+		luaRule   = "function check(data) return true end"
+		tableRule = "age,eligible\n-,true\n"
+	)
+	composite, dir := newLanguageTestEngine(t, map[string]string{
+		"go_rule.lua":       luaRule,
+		"go_rule.expr":      "true",
+		"expr_rule.lua":     luaRule,
+		"expr_rule.dt.csv":  tableRule,
+		"expr_rule.expr":    "true",
+		"table_rule.lua":    luaRule,
+		"table_rule.dt.csv": tableRule,
+		"lua_rule.lua":      luaRule,
+	})
+	storage := NewFileRuleStorage(dir)
+
+	for _, name := range []string{"go_rule", "expr_rule", "table_rule", "lua_rule"} {
+		t.Run(name, func(t *testing.T) {
+			engine, _, err := composite.Resolve(Rule{Name: name})
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			if name == "go_rule" {
+				if engine.Language() != LanguageGo {
+					t.Errorf("resolved to %s, want Go", engine.Language())
+				}
+				return
+			}
+
+			rule, err := storage.LoadRule(context.Background(), name)
+			if err != nil {
+				t.Fatalf("LoadRule: %v", err)
+			}
+			if rule.Language != engine.Language() {
+				t.Errorf("LoadRule returned the %s rule, but Resolve chose %s", rule.Language, engine.Language())
+			}
+		})
+	}
+}
+
+func TestResolveLanguage(t *testing.T) {
+	composite, _ := newLanguageTestEngine(t, map[string]string{
+		"check.expr": "true",
+		"check.lua":  "function check(data) return false end",
+	})
+
+	tests := []struct {
+		rule     Rule
+		want     string
+		notFound bool
+		wantErr  bool
+	}{
+		{rule: Rule{Name: "check"}, want: LanguageExpr},
+		{rule: Rule{Name: "check", Language: "lua"}, want: LanguageLua},
+		{rule: Rule{Name: "check", Language: LanguageDecisionTable}, notFound: true},
+		{rule: Rule{Name: "go_rule", Language: LanguageLua}, notFound: true},
+		{rule: Rule{Name: "check", Language: "JavaScript"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule.Name+"/"+tt.rule.Language, func(t *testing.T) {
+			engine, name, err := composite.Resolve(tt.rule)
+			switch {
+			case tt.notFound:
+				if !errors.Is(err, ErrRuleNotFound) {
+					t.Fatalf("err = %v, want ErrRuleNotFound", err)
+				}
+			case tt.wantErr:
+				if err == nil || errors.Is(err, ErrRuleNotFound) {
+					t.Fatalf("err = %v, want an unknown language error", err)
+				}
+			case err != nil:
+				t.Fatalf("Resolve: %v", err)
+			case engine.Language() != tt.want || name != tt.rule.Name:
+				t.Errorf("resolved to %s rule %q, want %s rule %q", engine.Language(), name, tt.want, tt.rule.Name)
+			}
+		})
+	}
+}
+
+func TestSaveRuleRemovesOtherLanguages(t *testing.T) {
+	composite, dir := newLanguageTestEngine(t, map[string]string{
+		"check.expr":   "false",
+		"check.dt.csv": "age,eligible\n-,false\n",
+	})
+	storage := NewFileRuleStorage(dir)
+
+	err := storage.SaveRule(context.Background(), Rule{Name: "check", Language: LanguageLua, Content: "function check(data) return true end"})
+	if err != nil {
+		t.Fatalf("SaveRule: %v", err)
+	}
+
+	for _, file := range []string{"check.expr", "check.dt.csv"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s was not removed: %v", file, err)
+		}
+	}
+	rule, err := storage.LoadRule(context.Background(), "check")
+	if err != nil || rule.Language != LanguageLua {
+		t.Fatalf("LoadRule = %+v, %v, want the Lua rule", rule, err)
+	}
+	passed, err := composite.Evaluate(context.Background(), "check", map[string]any{})
+	if err != nil || !passed {
+		t.Errorf("Evaluate = %v, %v, want the saved Lua rule to pass", passed, err)
+	}
+}
@@ -2,82 +2,425 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Storage backends for workflow instance state.
+const (
+	StorageFile   = "file"
+	StorageMemory = "memory"
 )
 
-// Config holds the workflow engine configuration
+// configEnvPrefix is the prefix of environment variables that override
+// configuration, as in MYWORKFLOW_LISTEN_ADDR.
+const configEnvPrefix = "MYWORKFLOW_"
+
+// Config holds the workflow engine configuration. Each field's key is its
+// yaml tag; the same key is used in JSON and legacy key=value files, as a
+// command-line flag with dashes (-listen-addr) and, upper-cased with the
+// MYWORKFLOW_ prefix, as an environment variable.
 type Config struct {
-	WorkflowsDir           string
-	RulesDir               string
-	StatesDir              string
-	LuaPoolSize            int
-	LogLevel               string
-	LogFile                string
-	WorkflowTimeoutSeconds int
-}
-
-// LoadConfig loads configuration from a file
-func LoadConfig(filepath string) (*Config, error) {
-	file, err := os.Open(filepath)
+	ListenAddr              string `yaml:"listen_addr" json:"listen_addr"`
+	WorkflowsDir            string `yaml:"workflows_dir" json:"workflows_dir"`
+	RulesDir                string `yaml:"rules_dir" json:"rules_dir"`
+	StatesDir               string `yaml:"states_dir" json:"states_dir"`
+	StorageBackend          string `yaml:"storage_backend" json:"storage_backend"`
+	LuaPoolSize             int    `yaml:"lua_pool_size" json:"lua_pool_size"`
+	LogLevel                string `yaml:"log_level" json:"log_level"`
+	LogFile                 string `yaml:"log_file" json:"log_file"`
+	LogFormat               string `yaml:"log_format" json:"log_format"`
+	LogMaxSizeMB            int    `yaml:"log_max_size_mb" json:"log_max_size_mb"`
+	LogMaxBackups           int    `yaml:"log_max_backups" json:"log_max_backups"`
+	WorkflowTimeoutSeconds  int    `yaml:"workflow_timeout_seconds" json:"workflow_timeout_seconds"`
+	MaxConcurrentExecutions int    `yaml:"max_concurrent_executions" json:"max_concurrent_executions"`
+	RuleCacheTTLSeconds     int    `yaml:"rule_cache_ttl_seconds" json:"rule_cache_ttl_seconds"`
+	RuleVersioning          bool   `yaml:"rule_versioning" json:"rule_versioning"`
+	AnalyticsFile           string `yaml:"analytics_file" json:"analytics_file"`
+	TracingExporter         string `yaml:"tracing_exporter" json:"tracing_exporter"`
+	TracingFile             string `yaml:"tracing_file" json:"tracing_file"`
+	TracingOTLPEndpoint     string `yaml:"tracing_otlp_endpoint" json:"tracing_otlp_endpoint"`
+	TracingServiceName      string `yaml:"tracing_service_name" json:"tracing_service_name"`
+	WebhooksFile            string `yaml:"webhooks_file" json:"webhooks_file"`
+	WebhookLogFile          string `yaml:"webhook_log_file" json:"webhook_log_file"`
+	OutboxDir               string `yaml:"outbox_dir" json:"outbox_dir"`
+	OutboxWorkers           int    `yaml:"outbox_workers" json:"outbox_workers"`
+	ReadTimeoutSeconds      int    `yaml:"read_timeout_seconds" json:"read_timeout_seconds"`
+	WriteTimeoutSeconds     int    `yaml:"write_timeout_seconds" json:"write_timeout_seconds"`
+	TLSCertFile             string `yaml:"tls_cert_file" json:"tls_cert_file"`
+	TLSKeyFile              string `yaml:"tls_key_file" json:"tls_key_file"`
+	AuthToken               string `yaml:"auth_token" json:"auth_token"`
+}
+
+// hotConfigKeys are the settings a running server applies without a
+// restart. Every other setting is read only at startup.
+var hotConfigKeys = map[string]bool{
+	"log_level":                 true,
+	"workflow_timeout_seconds":  true,
+	"max_concurrent_executions": true,
+	"rule_cache_ttl_seconds":    true,
+	"rule_versioning":           true,
+}
+
+// DefaultConfig returns the configuration used when no file is present
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddr:              ":8080",
+		WorkflowsDir:            "./workflows",
+		RulesDir:                "./rules",
+		StatesDir:               "./states",
+		StorageBackend:          StorageFile,
+		LuaPoolSize:             10,
+		LogLevel:                "info",
+		LogFile:                 "workflow.log",
+		LogFormat:               LogFormatText,
+		LogMaxSizeMB:            10,
+		LogMaxBackups:           5,
+		WorkflowTimeoutSeconds:  30,
+		MaxConcurrentExecutions: 100,
+		RuleCacheTTLSeconds:     300,
+		AnalyticsFile:           "analytics.json",
+		TracingExporter:         TracingNone,
+		TracingFile:             "traces.jsonl",
+		TracingOTLPEndpoint:     "http://localhost:4318/v1/traces",
+		TracingServiceName:      "myworkflow",
+		WebhookLogFile:          "webhook_deliveries.jsonl",
+		OutboxDir:               "outbox",
+		OutboxWorkers:           4,
+		ReadTimeoutSeconds:      15,
+		WriteTimeoutSeconds:     60,
+	}
+}
+
+// LoadConfig loads configuration from a file on top of the defaults. The
+// format follows the extension: .yaml or .yml for YAML, .json for JSON, and
+// anything else for the legacy key=value format. Unknown keys and invalid
+// values are errors.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open config file: %w", err)
 	}
-	defer file.Close()
 
-	config := &Config{
-		WorkflowsDir:           "./workflows",
-		RulesDir:               "./rules",
-		StatesDir:              "./states",
-		LuaPoolSize:            10,
-		LogLevel:               "info",
-		LogFile:                "workflow.log",
-		WorkflowTimeoutSeconds: 30,
+	config := DefaultConfig()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	default:
+		if err := config.loadKeyValues(data); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	return config, nil
+}
+
+// SaveKeys writes the values of the given keys to the configuration file at
+// path, in the format LoadConfig reads for its extension, and leaves the
+// rest of the file as it is. Comments in YAML and key=value files are kept.
+// The file is created if it does not exist.
+func (c *Config) SaveKeys(path string, keys []string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err = c.updateYAML(data, keys)
+	case ".json":
+		data, err = c.updateJSON(data, keys)
+	default:
+		data, err = c.updateKeyValues(data, keys)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
 	}
+	return nil
+}
+
+func (c *Config) updateYAML(data []byte, keys []string) ([]byte, error) {
+	return c.updateLines(data, keys, ": ", func(v any) (string, error) {
+		encoded, err := yaml.Marshal(v)
+		return strings.TrimSpace(string(encoded)), err
+	})
+}
 
-	scanner := bufio.NewScanner(file)
+func (c *Config) updateJSON(data []byte, keys []string) ([]byte, error) {
+	values := make(map[string]any)
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range keys {
+		field, err := c.field(key)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = field.Interface()
+	}
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (c *Config) updateKeyValues(data []byte, keys []string) ([]byte, error) {
+	return c.updateLines(data, keys, "=", func(v any) (string, error) {
+		return fmt.Sprint(v), nil
+	})
+}
+
+// updateLines rewrites the top-level line that sets each key, keeping every
+// other line, including comments and blank lines, as it is. In YAML a
+// comment after the value is kept too. Keys the file doesn't set are
+// appended.
+func (c *Config) updateLines(data []byte, keys []string, sep string, format func(v any) (string, error)) ([]byte, error) {
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		field, err := c.field(key)
+		if err != nil {
+			return nil, err
+		}
+		if values[key], err = format(field.Interface()); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
+		line := scanner.Text()
+		key, rest, ok := strings.Cut(line, strings.TrimSpace(sep))
+		value, pending := values[strings.TrimSpace(key)]
+		if ok && pending && strings.TrimLeft(key, " \t") == key {
+			comment := ""
+			if sep == ": " {
+				if i := strings.Index(rest, " #"); i >= 0 {
+					comment = rest[i:]
+				}
+			}
+			line = strings.TrimSpace(key) + sep + value + comment
+			delete(values, strings.TrimSpace(key))
+		}
+		buf.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if value, pending := values[key]; pending {
+			buf.WriteString(key + sep + value + "\n")
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// loadKeyValues applies a legacy key=value file. Blank lines and lines
+// starting with # are ignored.
+func (c *Config) loadKeyValues(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
 			continue
 		}
 
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("line %d: expected key=value", line)
+		}
+		if err := c.Set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// ApplyEnv overrides configuration from MYWORKFLOW_* entries in environ,
+// given in the form returned by os.Environ. Variables that don't name a
+// configuration key are logged and ignored.
+func (c *Config) ApplyEnv(environ []string) error {
+	for _, entry := range environ {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(name, configEnvPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, configEnvPrefix))
+		if _, err := c.field(key); err != nil {
+			slog.Warn("ignoring environment variable", "name", name, "error", err)
 			continue
 		}
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
 
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
+// ConfigKeys returns the keys of all configuration fields, in field order.
+func ConfigKeys() []string {
+	t := reflect.TypeOf(Config{})
+	keys := make([]string, t.NumField())
+	for i := range keys {
+		keys[i] = configKey(t.Field(i))
+	}
+	return keys
+}
 
-		switch key {
-		case "workflows_dir":
-			config.WorkflowsDir = value
-		case "rules_dir":
-			config.RulesDir = value
-		case "states_dir":
-			config.StatesDir = value
-		case "lua_pool_size":
-			if size, err := strconv.Atoi(value); err == nil {
-				config.LuaPoolSize = size
-			}
-		case "log_level":
-			config.LogLevel = value
-		case "log_file":
-			config.LogFile = value
-		case "workflow_timeout_seconds":
-			if timeout, err := strconv.Atoi(value); err == nil {
-				config.WorkflowTimeoutSeconds = timeout
-			}
+// Get returns the value of a configuration field as a string.
+func (c *Config) Get(key string) (string, error) {
+	field, err := c.field(key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(field.Interface()), nil
+}
+
+// Set parses value and assigns it to the configuration field with the
+// given key.
+func (c *Config) Set(key, value string) error {
+	field, err := c.field(key)
+	if err != nil {
+		return err
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", key, value)
 		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", key, value)
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("%s: unsupported field type %s", key, field.Kind())
 	}
+	return nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading config file: %w", err)
+// Diff returns the keys whose values differ between c and other, in field
+// order.
+func (c *Config) Diff(other *Config) []string {
+	var keys []string
+	a, b := reflect.ValueOf(c).Elem(), reflect.ValueOf(other).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		if a.Field(i).Interface() != b.Field(i).Interface() {
+			keys = append(keys, configKey(a.Type().Field(i)))
+		}
 	}
+	return keys
+}
 
-	return config, nil
-}
\ No newline at end of file
+// IsHotConfigKey reports whether a change to the setting takes effect in a
+// running server without a restart.
+func IsHotConfigKey(key string) bool {
+	return hotConfigKeys[key]
+}
+
+func (c *Config) field(key string) (reflect.Value, error) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if configKey(v.Type().Field(i)) == key {
+			return v.Field(i), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown config key %q", key)
+}
+
+func configKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return key
+}
+
+// Validate checks that the configuration values are usable.
+func (c *Config) Validate() error {
+	if problems := c.problems(); len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// problems lists every unusable configuration value.
+func (c *Config) problems() []string {
+	var problems []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.ListenAddr != "", "listen_addr is required")
+	check(c.WorkflowsDir != "", "workflows_dir is required")
+	check(c.RulesDir != "", "rules_dir is required")
+	check(c.StorageBackend == StorageFile || c.StorageBackend == StorageMemory,
+		"storage_backend must be %q or %q, got %q", StorageFile, StorageMemory, c.StorageBackend)
+	check(c.StorageBackend != StorageFile || c.StatesDir != "", "states_dir is required for file storage")
+	check(c.LuaPoolSize > 0, "lua_pool_size must be positive, got %d", c.LuaPoolSize)
+	check(c.LogLevel == "debug" || c.LogLevel == "info" || c.LogLevel == "warn" || c.LogLevel == "error",
+		"log_level must be debug, info, warn or error, got %q", c.LogLevel)
+	check(c.LogFormat == LogFormatText || c.LogFormat == LogFormatJSON,
+		"log_format must be %q or %q, got %q", LogFormatText, LogFormatJSON, c.LogFormat)
+	check(c.LogMaxSizeMB >= 0, "log_max_size_mb must not be negative")
+	check(c.LogMaxBackups >= 0, "log_max_backups must not be negative")
+	check(c.WorkflowTimeoutSeconds >= 0, "workflow_timeout_seconds must not be negative")
+	check(c.MaxConcurrentExecutions >= 0, "max_concurrent_executions must not be negative")
+	check(c.RuleCacheTTLSeconds >= 0, "rule_cache_ttl_seconds must not be negative")
+	check(c.OutboxDir != "", "outbox_dir is required")
+	check(c.OutboxWorkers > 0, "outbox_workers must be positive, got %d", c.OutboxWorkers)
+	check(c.ReadTimeoutSeconds >= 0, "read_timeout_seconds must not be negative")
+	check(c.WriteTimeoutSeconds >= 0, "write_timeout_seconds must not be negative")
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "tls_cert_file and tls_key_file must be set together")
+	check(c.TracingExporter == TracingNone || c.TracingExporter == TracingStdout || c.TracingExporter == TracingFile || c.TracingExporter == TracingOTLP,
+		"tracing_exporter must be none, stdout, file or otlp, got %q", c.TracingExporter)
+	check(c.TracingExporter != TracingFile || c.TracingFile != "", "tracing_file is required for the file exporter")
+	check(c.TracingExporter != TracingOTLP || c.TracingOTLPEndpoint != "", "tracing_otlp_endpoint is required for the otlp exporter")
+	check(c.TracingServiceName != "", "tracing_service_name is required")
+
+	return problems
+}
+
+// configFileExists reports whether a configuration file is present
+func configFileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
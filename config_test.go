@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	cfg := DefaultConfig()
+	err := cfg.ApplyEnv([]string{
+		"MYWORKFLOW_LISTEN_ADDR=:9090",
+		"MYWORKFLOW_LUA_POOL_SIZE=20",
+		"MYWORKFLOW_HOME=/opt/myworkflow",
+		"PATH=/usr/bin",
+		"MYWORKFLOW_RULE_VERSIONING=true",
+	})
+	if err != nil {
+		t.Fatalf("ApplyEnv: %v", err)
+	}
+	if cfg.ListenAddr != ":9090" || cfg.LuaPoolSize != 20 || !cfg.RuleVersioning {
+		t.Errorf("config = %+v", cfg)
+	}
+
+	err = DefaultConfig().ApplyEnv([]string{"MYWORKFLOW_LUA_POOL_SIZE=many"})
+	if err == nil || !strings.Contains(err.Error(), "MYWORKFLOW_LUA_POOL_SIZE") {
+		t.Errorf("err = %v, want an error naming MYWORKFLOW_LUA_POOL_SIZE", err)
+	}
+}
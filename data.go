@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+)
+
+// DiffData returns the keys in updated whose values differ from those in
+// original, and the keys of original missing from updated as removed.
+func DiffData(original, updated map[string]any) DataDiff {
+	diff := make(DataDiff)
+	for key, newValue := range updated {
+		oldValue, exists := original[key]
+		if exists && valuesEqual(oldValue, newValue) {
+			continue
+		}
+		diff[key] = DataChange{Old: oldValue, New: newValue}
+	}
+	for key, oldValue := range original {
+		if _, exists := updated[key]; !exists {
+			diff[key] = DataChange{Old: oldValue, Removed: true}
+		}
+	}
+	return diff
+}
+
+// MergeData applies a diff to the given data map in place.
+func MergeData(data map[string]any, diff DataDiff) {
+	for key, change := range diff {
+		if change.Removed {
+			delete(data, key)
+		} else {
+			data[key] = change.New
+		}
+	}
+}
+
+// CopyData returns a deep copy of workflow data, so that one set of input
+// data can be run more than once.
+func CopyData(data map[string]any) map[string]any {
+	if data == nil {
+		return nil
+	}
+	return copyValue(data).(map[string]any)
+}
+
+func copyValue(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(val))
+		for k, item := range val {
+			copied[k] = copyValue(item)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(val))
+		for i, item := range val {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	case []string:
+		return append([]string(nil), val...)
+	default:
+		return val
+	}
+}
+
+// valuesEqual compares two data values, treating all numeric types as equal
+// when they hold the same value.
+func valuesEqual(a, b any) bool {
+	return reflect.DeepEqual(normalizeValue(a), normalizeValue(b))
+}
+
+// normalizeValue converts numbers to float64, recursively.
+func normalizeValue(v any) any {
+	switch val := v.(type) {
+	case int:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case float32:
+		return float64(val)
+	case []string:
+		items := make([]any, len(val))
+		for i, item := range val {
+			items[i] = item
+		}
+		return items
+	case []any:
+		items := make([]any, len(val))
+		for i, item := range val {
+			items[i] = normalizeValue(item)
+		}
+		return items
+	case map[string]any:
+		m := make(map[string]any, len(val))
+		for k, item := range val {
+			m[k] = normalizeValue(item)
+		}
+		return m
+	default:
+		return v
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffData(t *testing.T) {
+	tests := []struct {
+		name     string
+		original map[string]any
+		updated  map[string]any
+		want     DataDiff
+	}{
+		{
+			name:     "unchanged",
+			original: map[string]any{"age": 30, "tags": []string{"a"}},
+			updated:  map[string]any{"age": 30.0, "tags": []any{"a"}},
+			want:     DataDiff{},
+		},
+		{
+			name:     "added and changed",
+			original: map[string]any{"age": 30, "name": "sam"},
+			updated:  map[string]any{"age": 31, "name": "sam", "adult": true},
+			want: DataDiff{
+				"age":   {Old: 30, New: 31},
+				"adult": {New: true},
+			},
+		},
+		{
+			name:     "removed",
+			original: map[string]any{"age": 30, "name": "sam", "note": nil},
+			updated:  map[string]any{"age": 30},
+			want: DataDiff{
+				"name": {Old: "sam", Removed: true},
+				"note": {Removed: true},
+			},
+		},
+		{
+			name:     "set to nil",
+			original: map[string]any{"name": "sam"},
+			updated:  map[string]any{"name": nil},
+			want:     DataDiff{"name": {Old: "sam"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffData(tt.original, tt.updated)
+			if !reflect.DeepEqual(diff, tt.want) {
+				t.Fatalf("diff = %#v, want %#v", diff, tt.want)
+			}
+
+			data := CopyData(tt.original)
+			MergeData(data, diff)
+			if !reflect.DeepEqual(DiffData(data, tt.updated), DataDiff{}) {
+				t.Errorf("merged data = %#v, want %#v", data, tt.updated)
+			}
+		})
+	}
+}
+
+func TestCopyData(t *testing.T) {
+	data := map[string]any{
+		"address": map[string]any{"city": "Oslo"},
+		"items":   []any{map[string]any{"sku": "a"}},
+		"tags":    []string{"new"},
+	}
+	copied := CopyData(data)
+	copied["address"].(map[string]any)["city"] = "Bergen"
+	copied["items"].([]any)[0].(map[string]any)["sku"] = "b"
+	copied["tags"].([]string)[0] = "old"
+
+	want := map[string]any{
+		"address": map[string]any{"city": "Oslo"},
+		"items":   []any{map[string]any{"sku": "a"}},
+		"tags":    []string{"new"},
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("original changed to %#v", data)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Decision table hit policies.
+const (
+	// HitPolicyFirst returns the outcome of the first matching row.
+	HitPolicyFirst = "first"
+	// HitPolicyUnique requires at most one row to match.
+	HitPolicyUnique = "unique"
+	// HitPolicyCollect returns the outcomes of all matching rows.
+	HitPolicyCollect = "collect"
+)
+
+// DecisionTable is a spreadsheet-style rule. Each row holds one condition per
+// input column and an outcome; a row matches when all its conditions match
+// the workflow data.
+//
+// Conditions are written as unary tests against the input value:
+//
+//	-, or empty       any value
+//	premium, "a b"    equal to the value
+//	!= premium        not equal to the value
+//	< 18, >= 18       compared with a number or string
+//	[18..65], (0..1]  within a range, inclusive with [ ] and exclusive with ( )
+//	gold, premium     any of several tests, separated by commas
+type DecisionTable struct {
+	HitPolicy string
+	Inputs    []string
+	Output    string
+	Rows      []DecisionRow
+	tests     [][]cellTest
+}
+
+// decisionTableYAML is the YAML form of a DecisionTable.
+type decisionTableYAML struct {
+	HitPolicy string        `yaml:"hit_policy"`
+	Inputs    []string      `yaml:"inputs"`
+	Output    string        `yaml:"output"`
+	Rows      [][]yaml.Node `yaml:"rows"`
+}
+
+// DecisionRow is one row of a decision table.
+type DecisionRow struct {
+	Conditions []string
+	Outcome    string
+}
+
+// ParseDecisionTable parses a decision table written as YAML or CSV.
+func ParseDecisionTable(content string) (*DecisionTable, error) {
+	if DecisionTableFormat(content) == DecisionTableYAML {
+		return ParseDecisionTableYAML(content)
+	}
+	return ParseDecisionTableCSV(content)
+}
+
+// DecisionTableFormat reports whether a decision table's source is YAML or
+// CSV. Source that parses as a YAML mapping is YAML; anything else is CSV.
+func DecisionTableFormat(content string) string {
+	var probe map[string]any
+	if err := yaml.Unmarshal([]byte(content), &probe); err == nil && probe != nil {
+		return DecisionTableYAML
+	}
+	return DecisionTableCSV
+}
+
+// ParseDecisionTableYAML parses a decision table such as
+//
+//	hit_policy: first
+//	inputs: [age, customer_type]
+//	output: eligible
+//	rows:
+//	  - ["< 18", "-", false]
+//	  - ["-", premium, true]
+func ParseDecisionTableYAML(content string) (*DecisionTable, error) {
+	var doc decisionTableYAML
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid decision table: %w", err)
+	}
+
+	table := DecisionTable{HitPolicy: doc.HitPolicy, Inputs: doc.Inputs, Output: doc.Output}
+	for i, raw := range doc.Rows {
+		cells := make([]string, len(raw))
+		for j, node := range raw {
+			if node.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("row %d, column %d: cells must be scalar values", i+1, j+1)
+			}
+			cells[j] = node.Value
+		}
+		row, err := newDecisionRow(cells, len(table.Inputs))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+
+	return &table, table.compile()
+}
+
+// ParseDecisionTableCSV parses a decision table from CSV. The header names
+// the input columns followed by the output column, and each following line
+// is a row. An optional first line "# hit_policy: collect" sets the hit
+// policy:
+//
+//	# hit_policy: first
+//	age,customer_type,eligible
+//	< 18,-,false
+//	-,premium,true
+func ParseDecisionTableCSV(content string) (*DecisionTable, error) {
+	table := DecisionTable{}
+
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			key, value, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(trimmed, "#")), ":")
+			if ok && strings.TrimSpace(key) == "hit_policy" {
+				table.HitPolicy = strings.TrimSpace(value)
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	reader := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid decision table: %w", err)
+	}
+	if len(records) == 0 || len(records[0]) < 2 {
+		return nil, fmt.Errorf("invalid decision table: header must name at least one input and the output")
+	}
+
+	header := records[0]
+	for _, name := range header[:len(header)-1] {
+		table.Inputs = append(table.Inputs, strings.TrimSpace(name))
+	}
+	table.Output = strings.TrimSpace(header[len(header)-1])
+
+	for i, record := range records[1:] {
+		row, err := newDecisionRow(record, len(table.Inputs))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+
+	return &table, table.compile()
+}
+
+func newDecisionRow(cells []string, inputs int) (DecisionRow, error) {
+	if len(cells) != inputs+1 {
+		return DecisionRow{}, fmt.Errorf("expected %d conditions and an outcome, got %d cells", inputs, len(cells))
+	}
+	return DecisionRow{
+		Conditions: cells[:inputs],
+		Outcome:    strings.TrimSpace(cells[inputs]),
+	}, nil
+}
+
+// compile validates the table and parses its conditions.
+func (t *DecisionTable) compile() error {
+	switch t.HitPolicy {
+	case "":
+		t.HitPolicy = HitPolicyFirst
+	case HitPolicyFirst, HitPolicyUnique, HitPolicyCollect:
+	default:
+		return fmt.Errorf("unknown hit policy '%s'", t.HitPolicy)
+	}
+	if len(t.Inputs) == 0 {
+		return fmt.Errorf("decision table has no inputs")
+	}
+
+	t.tests = make([][]cellTest, len(t.Rows))
+	for i, row := range t.Rows {
+		if len(row.Conditions) != len(t.Inputs) {
+			return fmt.Errorf("row %d: expected %d conditions, got %d", i+1, len(t.Inputs), len(row.Conditions))
+		}
+		t.tests[i] = make([]cellTest, len(row.Conditions))
+		for j, cell := range row.Conditions {
+			test, err := parseCellTest(cell)
+			if err != nil {
+				return fmt.Errorf("row %d, column '%s': %w", i+1, t.Inputs[j], err)
+			}
+			t.tests[i][j] = test
+		}
+	}
+	return nil
+}
+
+// Decide evaluates the table against data. It returns whether any row
+// matched and the outcome: a single value for the first and unique hit
+// policies, and a list of values for collect.
+func (t *DecisionTable) Decide(data map[string]any) (bool, any, error) {
+	var outcomes []any
+	for i, row := range t.Rows {
+		if !t.rowMatches(i, data) {
+			continue
+		}
+		outcomes = append(outcomes, parseCellValue(row.Outcome))
+		if t.HitPolicy == HitPolicyFirst {
+			break
+		}
+	}
+
+	switch {
+	case len(outcomes) == 0:
+		return false, nil, nil
+	case t.HitPolicy == HitPolicyCollect:
+		return true, outcomes, nil
+	case t.HitPolicy == HitPolicyUnique && len(outcomes) > 1:
+		return false, nil, fmt.Errorf("%d rows matched but the hit policy is unique", len(outcomes))
+	default:
+		return true, outcomes[0], nil
+	}
+}
+
+// Evaluate evaluates the table as a rule. A boolean outcome is the result;
+// any other outcome counts as true. Under the collect policy the result is
+// true if any collected outcome is.
+func (t *DecisionTable) Evaluate(data map[string]any) (bool, any, error) {
+	matched, outcome, err := t.Decide(data)
+	if err != nil || !matched {
+		return false, outcome, err
+	}
+
+	if outcomes, ok := outcome.([]any); ok && t.HitPolicy == HitPolicyCollect {
+		for _, o := range outcomes {
+			if b, isBool := o.(bool); !isBool || b {
+				return true, outcome, nil
+			}
+		}
+		return false, outcome, nil
+	}
+
+	if b, ok := outcome.(bool); ok {
+		return b, outcome, nil
+	}
+	return true, outcome, nil
+}
+
+func (t *DecisionTable) rowMatches(row int, data map[string]any) bool {
+	for j, input := range t.Inputs {
+		value, exists := lookupPath(data, input)
+		if !t.tests[row][j].matches(value, exists) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupPath reads a value from data by a dotted path such as
+// "address.country".
+func lookupPath(data map[string]any, path string) (any, bool) {
+	var current any = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return normalizeValue(current), true
+}
+
+// Cell tests
+
+// cellTest is a parsed condition cell; a nil alternatives list matches any
+// value.
+type cellTest struct {
+	alternatives []cellCondition
+}
+
+type cellCondition struct {
+	op    string // "=", "!=", "<", "<=", ">", ">=" or "range"
+	value any
+	low   any
+	high  any
+	lowIn bool
+	hiIn  bool
+}
+
+func parseCellTest(cell string) (cellTest, error) {
+	cell = strings.TrimSpace(cell)
+	if cell == "" || cell == "-" {
+		return cellTest{}, nil
+	}
+
+	var test cellTest
+	for _, part := range splitCellAlternatives(cell) {
+		cond, err := parseCellCondition(strings.TrimSpace(part))
+		if err != nil {
+			return cellTest{}, err
+		}
+		test.alternatives = append(test.alternatives, cond)
+	}
+	return test, nil
+}
+
+// splitCellAlternatives splits a cell on commas outside quotes and ranges.
+func splitCellAlternatives(cell string) []string {
+	var parts []string
+	var quote rune
+	depth, start := 0, 0
+	for i, r := range cell {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[' || r == '(':
+			depth++
+		case r == ']' || r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, cell[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, cell[start:])
+}
+
+func parseCellCondition(text string) (cellCondition, error) {
+	if text == "" {
+		return cellCondition{}, fmt.Errorf("empty condition")
+	}
+
+	if (text[0] == '[' || text[0] == '(') && (strings.HasSuffix(text, "]") || strings.HasSuffix(text, ")")) {
+		low, high, ok := strings.Cut(text[1:len(text)-1], "..")
+		if !ok {
+			return cellCondition{}, fmt.Errorf("invalid range '%s', expected [low..high]", text)
+		}
+		return cellCondition{
+			op:    "range",
+			low:   parseCellValue(low),
+			high:  parseCellValue(high),
+			lowIn: text[0] == '[',
+			hiIn:  strings.HasSuffix(text, "]"),
+		}, nil
+	}
+
+	if (text[0] == '[' || text[0] == '(') && strings.Contains(text, "..") {
+		return cellCondition{}, fmt.Errorf("unterminated range '%s'", text)
+	}
+
+	for _, op := range []string{"!=", "<=", ">=", "<", ">", "="} {
+		if strings.HasPrefix(text, op) {
+			operand := strings.TrimSpace(strings.TrimPrefix(text, op))
+			if operand == "" {
+				return cellCondition{}, fmt.Errorf("missing value after '%s'", op)
+			}
+			return cellCondition{op: op, value: parseCellValue(operand)}, nil
+		}
+	}
+
+	return cellCondition{op: "=", value: parseCellValue(text)}, nil
+}
+
+// parseCellValue converts cell text to a number, boolean, null or string.
+// Quoted text is always a string.
+func parseCellValue(text string) any {
+	text = strings.TrimSpace(text)
+	if len(text) >= 2 && (text[0] == '"' || text[0] == '\'') && text[len(text)-1] == text[0] {
+		return text[1 : len(text)-1]
+	}
+	switch text {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if n, err := strconv.ParseFloat(text, 64); err == nil && !math.IsNaN(n) {
+		return n
+	}
+	return text
+}
+
+func (t cellTest) matches(value any, exists bool) bool {
+	if t.alternatives == nil {
+		return true
+	}
+	if !exists {
+		return false
+	}
+	for _, cond := range t.alternatives {
+		if cond.matches(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c cellCondition) matches(value any) bool {
+	switch c.op {
+	case "=":
+		return valuesEqual(value, c.value)
+	case "!=":
+		return !valuesEqual(value, c.value)
+	case "range":
+		low, err := compareValues(value, c.low)
+		if err != nil || low < 0 || (low == 0 && !c.lowIn) {
+			return false
+		}
+		high, err := compareValues(value, c.high)
+		if err != nil || high > 0 || (high == 0 && !c.hiIn) {
+			return false
+		}
+		return true
+	default:
+		cmp, err := compareValues(value, c.value)
+		if err != nil {
+			return false
+		}
+		switch c.op {
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+	}
+}
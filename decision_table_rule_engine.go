@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Decision table file formats and the extensions they are stored under.
+const (
+	DecisionTableYAML = "yaml"
+	DecisionTableCSV  = "csv"
+)
+
+var decisionTableExtensions = map[string]string{
+	DecisionTableYAML: ".dt.yaml",
+	DecisionTableCSV:  ".dt.csv",
+}
+
+// DecisionTableRuleEngine implements the RuleEngine interface for decision
+// tables stored as .dt.yaml or .dt.csv files in the rules directory.
+type DecisionTableRuleEngine struct {
+	rulesDir string
+	named    map[string]*DecisionTable
+	files    map[string]*compiledTable
+	cacheTTL time.Duration
+	mu       sync.RWMutex
+}
+
+// compiledTable is a parsed decision table file together with its
+// modification time.
+type compiledTable struct {
+	table      *DecisionTable
+	modTime    time.Time
+	compiledAt time.Time
+}
+
+// NewDecisionTableRuleEngine creates a new decision table rule engine
+func NewDecisionTableRuleEngine(rulesDir string) *DecisionTableRuleEngine {
+	return &DecisionTableRuleEngine{
+		rulesDir: rulesDir,
+		named:    make(map[string]*DecisionTable),
+		files:    make(map[string]*compiledTable),
+	}
+}
+
+// Evaluate evaluates a decision table against the workflow data.
+func (d *DecisionTableRuleEngine) Evaluate(ctx context.Context, ruleName string, data map[string]any) (bool, error) {
+	table, err := d.getTable(ruleName)
+	if err != nil {
+		return false, err
+	}
+
+	result, _, err := table.Evaluate(data)
+	if err != nil {
+		return false, fmt.Errorf("decision table '%s': %w", ruleName, err)
+	}
+	return result, nil
+}
+
+// EvaluateMutating evaluates a decision table and, when a row matched and
+// the table names an output column, writes the outcome to that field.
+func (d *DecisionTableRuleEngine) EvaluateMutating(ctx context.Context, ruleName string, data map[string]any) (bool, map[string]any, error) {
+	table, err := d.getTable(ruleName)
+	if err != nil {
+		return false, nil, err
+	}
+
+	result, outcome, err := table.Evaluate(data)
+	if err != nil {
+		return false, nil, fmt.Errorf("decision table '%s': %w", ruleName, err)
+	}
+
+	updated := make(map[string]any, len(data)+1)
+	for k, v := range data {
+		updated[k] = v
+	}
+	if outcome != nil && table.Output != "" {
+		updated[table.Output] = outcome
+	}
+	return result, updated, nil
+}
+
+// RegisterRule registers a decision table, given either as a
+// *DecisionTable or as YAML or CSV source text.
+func (d *DecisionTableRuleEngine) RegisterRule(name string, rule any) error {
+	var table *DecisionTable
+	switch r := rule.(type) {
+	case *DecisionTable:
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("invalid decision table '%s': %w", name, err)
+		}
+		table = r
+	case string:
+		parsed, err := ParseDecisionTable(r)
+		if err != nil {
+			return fmt.Errorf("invalid decision table '%s': %w", name, err)
+		}
+		table = parsed
+	default:
+		return fmt.Errorf("decision table must be a *DecisionTable or source text, got %T", rule)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.named[name] = table
+	return nil
+}
+
+// Language returns the rule language
+func (d *DecisionTableRuleEngine) Language() string {
+	return LanguageDecisionTable
+}
+
+// Extensions returns the rule file extensions
+func (d *DecisionTableRuleEngine) Extensions() []string {
+	return []string{decisionTableExtensions[DecisionTableYAML], decisionTableExtensions[DecisionTableCSV]}
+}
+
+// HasRule reports whether ruleName is a registered table or a table file.
+func (d *DecisionTableRuleEngine) HasRule(ruleName string) bool {
+	d.mu.RLock()
+	_, ok := d.named[ruleName]
+	d.mu.RUnlock()
+	if ok {
+		return true
+	}
+
+	_, err := d.rulePath(ruleName)
+	return err == nil
+}
+
+// Check verifies that every input column of the table is a field declared
+// in the schema.
+func (d *DecisionTableRuleEngine) Check(ruleName string, schema ExprSchema) error {
+	table, err := d.getTable(ruleName)
+	if err != nil {
+		return err
+	}
+	if len(schema) == 0 {
+		return nil
+	}
+
+	for _, input := range table.Inputs {
+		if _, ok := schema[input]; !ok {
+			return fmt.Errorf("decision table '%s': input '%s' is not in the data schema", ruleName, input)
+		}
+	}
+	return nil
+}
+
+func (d *DecisionTableRuleEngine) getTable(ruleName string) (*DecisionTable, error) {
+	d.mu.RLock()
+	table, ok := d.named[ruleName]
+	d.mu.RUnlock()
+	if ok {
+		return table, nil
+	}
+
+	return d.getFile(ruleName)
+}
+
+// getFile returns the parsed table file for a rule, re-parsing it when the
+// file has changed.
+func (d *DecisionTableRuleEngine) getFile(ruleName string) (*DecisionTable, error) {
+	path, err := d.rulePath(ruleName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule file: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule file: %w", err)
+	}
+
+	d.mu.RLock()
+	cached, ok := d.files[path]
+	ttl := d.cacheTTL
+	d.mu.RUnlock()
+	if ok && ruleCacheFresh(cached.modTime, cached.compiledAt, info, ttl) {
+		return cached.table, nil
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule file: %w", err)
+	}
+
+	var table *DecisionTable
+	if filepath.Ext(path) == ".csv" {
+		table, err = ParseDecisionTableCSV(string(source))
+	} else {
+		table, err = ParseDecisionTableYAML(string(source))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid decision table in rule '%s': %w", ruleName, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.files[path] = &compiledTable{table: table, modTime: info.ModTime(), compiledAt: time.Now()}
+	return table, nil
+}
+
+// SetCacheTTL sets how long parsed table files are kept before they are
+// parsed again.
+func (d *DecisionTableRuleEngine) SetCacheTTL(ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cacheTTL = ttl
+}
+
+// rulePath returns the path of the table file for a rule, preferring YAML
+// when both formats exist.
+func (d *DecisionTableRuleEngine) rulePath(ruleName string) (string, error) {
+	var lastErr error
+	for _, ext := range d.Extensions() {
+		path := filepath.Join(d.rulesDir, ruleName+ext)
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
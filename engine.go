@@ -2,25 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
-	lua "github.com/yuin/gopher-lua"
 	"gopkg.in/yaml.v3"
 )
 
 // WorkflowEngine is the core orchestrator.
 type WorkflowEngine struct {
-	workflows     map[string]Workflow
-	ruleEngine    RuleEngine
-	storage       WorkflowStorage
-	stateStorage  StateStorage
-	eventHandlers []EventHandler
-	luaPool       *LuaStatePool // A pool of Lua states for performance
-	mu            sync.RWMutex
+	workflows    map[string]Workflow
+	ruleEngine   RuleEngine
+	storage      WorkflowStorage
+	stateStorage StateStorage
+	listeners    []EventListener
+	registry     *Registry
+	luaPool      *LuaStatePool // A pool of Lua states for performance
+	timeout      time.Duration
+	limiter      *executionLimiter
+	logger       *slog.Logger
+	tracer       *Tracer
+	outbox       *Outbox
+	mu           sync.RWMutex
 }
 
 // EngineOptions contains configuration for the workflow engine
@@ -29,6 +39,33 @@ type EngineOptions struct {
 	RulesDir      string
 	LuaPoolSize   int
 	EventHandlers []EventHandler
+	// Registry holds the Go rules and actions workflows can use. The rules
+	// of the workflows in WorkflowsDir must be registered in it before the
+	// engine is created, as the workflows are checked as they are loaded;
+	// nil starts with an empty registry.
+	Registry *Registry
+	// EventListeners receive every event as a WorkflowEvent, after the
+	// EventHandlers.
+	EventListeners []EventListener
+	// WorkflowTimeout bounds each run of a workflow; zero means no limit.
+	WorkflowTimeout time.Duration
+	// MaxConcurrentExecutions bounds the number of workflow runs in
+	// progress; further runs wait for a free slot. Zero means no limit.
+	MaxConcurrentExecutions int
+	// RuleCacheTTL is how long compiled rule files are kept before they
+	// are recompiled; zero keeps them until the file changes.
+	RuleCacheTTL time.Duration
+	// Logger receives the engine's log records; nil uses slog.Default().
+	Logger *slog.Logger
+	// Metrics, if set, receives execution, rule and Lua pool metrics; a
+	// MetricsEventHandler recording to it is added to the event handlers.
+	Metrics *EngineMetrics
+	// Tracer, if set, records a span for each workflow run, transition and
+	// rule evaluation.
+	Tracer *Tracer
+	// Outbox, if set, queues the events of async handlers for delivery in
+	// the background; see AsyncHandler.
+	Outbox *Outbox
 }
 
 // NewWorkflowEngine creates a new engine and loads workflows from a directory.
@@ -36,29 +73,83 @@ func NewWorkflowEngine(opts EngineOptions) (*WorkflowEngine, error) {
 	if opts.LuaPoolSize <= 0 {
 		opts.LuaPoolSize = 10
 	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	if opts.Registry == nil {
+		opts.Registry = NewRegistry()
+	}
 
 	engine := &WorkflowEngine{
-		workflows:     make(map[string]Workflow),
-		luaPool:       NewLuaStatePool(opts.LuaPoolSize),
-		eventHandlers: opts.EventHandlers,
+		workflows: make(map[string]Workflow),
+		registry:  opts.Registry,
+		luaPool:   NewLuaStatePool(opts.LuaPoolSize),
+		timeout:   opts.WorkflowTimeout,
+		limiter:   newExecutionLimiter(opts.MaxConcurrentExecutions),
+		logger:    opts.Logger,
+		tracer:    opts.Tracer,
+		outbox:    opts.Outbox,
 	}
 
-	// Initialize default rule engine
-	engine.ruleEngine = NewLuaRuleEngine(engine.luaPool, opts.RulesDir)
+	// Initialize default rule engine, dispatching in the order of ruleLanguages
+	luaEngine := NewLuaRuleEngine(engine.luaPool, opts.RulesDir)
+	engine.ruleEngine = NewCompositeRuleEngine(
+		NewGoRuleEngine(engine.registry),
+		NewExprRuleEngine(opts.RulesDir),
+		NewDecisionTableRuleEngine(opts.RulesDir),
+		luaEngine,
+	)
+
+	for _, handler := range opts.EventHandlers {
+		engine.listeners = append(engine.listeners, ListenerForHandler(handler))
+	}
+	engine.listeners = append(engine.listeners, opts.EventListeners...)
 
-	// Load workflows
-	if err := engine.loadWorkflows(opts.WorkflowsDir); err != nil {
-		return nil, fmt.Errorf("failed to load workflows: %w", err)
+	if opts.Metrics != nil {
+		luaEngine.SetMetrics(opts.Metrics)
+		engine.listeners = append(engine.listeners, ListenerForHandler(NewMetricsEventHandler(opts.Metrics)))
 	}
 
+	engine.SetRuleCacheTTL(opts.RuleCacheTTL)
+
 	// Register a simple "pass" rule that always returns true
 	if err := engine.registerPassRule(); err != nil {
 		return nil, fmt.Errorf("failed to register pass rule: %w", err)
 	}
 
+	// Load workflows, unless the engine is only used to check definitions
+	if opts.WorkflowsDir != "" {
+		if err := engine.loadWorkflows(opts.WorkflowsDir); err != nil {
+			return nil, fmt.Errorf("failed to load workflows: %w", err)
+		}
+	}
+
 	return engine, nil
 }
 
+// Phases in which step actions run.
+const (
+	actionOnEnter = "on_enter"
+	actionOnExit  = "on_exit"
+)
+
+// ActionError reports a step action that failed and halted an instance.
+type ActionError struct {
+	Step   string
+	Phase  string
+	Action string
+	Err    error
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("action '%s' failed in %s of step '%s': %v", e.Action, e.Phase, e.Step, e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
 // RegisterWorkflow adds a new workflow definition to the engine.
 func (e *WorkflowEngine) RegisterWorkflow(wf Workflow) {
 	e.mu.Lock()
@@ -73,6 +164,52 @@ func (e *WorkflowEngine) SetRuleEngine(engine RuleEngine) {
 	e.ruleEngine = engine
 }
 
+// AddRuleEngine adds a rule engine for another language to the default
+// dispatching rule engine
+func (e *WorkflowEngine) AddRuleEngine(engine LanguageRuleEngine) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	composite, ok := e.ruleEngine.(*CompositeRuleEngine)
+	if !ok {
+		return fmt.Errorf("rule engine does not dispatch by language")
+	}
+	composite.AddEngine(engine)
+	return nil
+}
+
+// SetWorkflowTimeout changes the time limit of workflow runs started from
+// now on; zero means no limit.
+func (e *WorkflowEngine) SetWorkflowTimeout(timeout time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.timeout = timeout
+}
+
+// SetMaxConcurrentExecutions changes the number of workflow runs allowed in
+// progress at once. Runs already in progress are not affected; zero means
+// no limit.
+func (e *WorkflowEngine) SetMaxConcurrentExecutions(limit int) {
+	e.limiter.setLimit(limit)
+}
+
+// SetRuleCacheTTL changes how long the rule engine keeps compiled rule
+// files, if it caches them.
+func (e *WorkflowEngine) SetRuleCacheTTL(ttl time.Duration) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if cache, ok := e.ruleEngine.(RuleCache); ok {
+		cache.SetCacheTTL(ttl)
+	}
+}
+
+// RuleEngine returns the rule engine
+func (e *WorkflowEngine) RuleEngine() RuleEngine {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.ruleEngine
+}
+
 // SetStorage allows setting custom workflow storage
 func (e *WorkflowEngine) SetStorage(storage WorkflowStorage) {
 	e.mu.Lock()
@@ -87,49 +224,192 @@ func (e *WorkflowEngine) SetStateStorage(storage StateStorage) {
 	e.stateStorage = storage
 }
 
-// AddEventHandler adds an event handler to the engine
+// RegisterRule registers a Go function that transitions can use as a rule.
+// Go rules take precedence over rule files of the same name.
+func (e *WorkflowEngine) RegisterRule(name string, fn RuleFunc) error {
+	return e.registry.RegisterRule(name, fn)
+}
+
+// RegisterAction registers a Go function that steps can run by name. Go
+// actions take precedence over action scripts of the same name.
+func (e *WorkflowEngine) RegisterAction(name string, fn ActionFunc) error {
+	return e.registry.RegisterAction(name, fn)
+}
+
+// Registry returns the registry of Go rules and actions
+func (e *WorkflowEngine) Registry() *Registry {
+	return e.registry
+}
+
+// AddEventHandler adds an event handler to the engine, adapted to an
+// EventListener.
 func (e *WorkflowEngine) AddEventHandler(handler EventHandler) {
+	e.AddEventListener(ListenerForHandler(handler))
+}
+
+// AddEventListener adds an event listener to the engine. Listeners, or the
+// handlers they adapt, implementing AsyncHandler are registered with the
+// outbox, if there is one.
+func (e *WorkflowEngine) AddEventListener(listener EventListener) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	e.eventHandlers = append(e.eventHandlers, handler)
+	e.listeners = append(e.listeners, listener)
+	if name, ok := asyncName(listener); ok && e.outbox != nil {
+		e.outbox.Register(name, listener)
+	}
+}
+
+// SetOutbox sets the outbox delivering the events of async handlers and
+// registers the async handlers added so far with it.
+func (e *WorkflowEngine) SetOutbox(outbox *Outbox) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.outbox = outbox
+	for _, listener := range e.listeners {
+		if name, ok := asyncName(listener); ok {
+			outbox.Register(name, listener)
+		}
+	}
 }
 
 func (e *WorkflowEngine) registerPassRule() error {
 	// For simplicity, we can have an in-memory rule that always returns true.
 	// This avoids creating a dedicated file for it.
-	passRuleFunc := func(l *lua.LState) int {
-		l.Push(lua.LBool(true))
-		return 1
-	}
+	return e.registry.RegisterRule("pass", func(ctx context.Context, data map[string]any) (bool, error) {
+		return true, nil
+	})
+}
 
-	l := e.luaPool.Get()
-	defer e.luaPool.Put(l)
-	
-	l.SetGlobal("pass", l.NewFunction(passRuleFunc))
-	return nil
+// Workflow returns the definition of a registered workflow.
+func (e *WorkflowEngine) Workflow(name string) (Workflow, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	wf, ok := e.workflows[name]
+	return wf, ok
 }
 
-// RunWorkflow executes a workflow from a given state.
-func (e *WorkflowEngine) RunWorkflow(ctx context.Context, wfName string, state *WorkflowState) error {
+// WorkflowNames returns the names of the registered workflows, sorted.
+func (e *WorkflowEngine) WorkflowNames() []string {
 	e.mu.RLock()
-	wf, ok := e.workflows[wfName]
-	e.mu.RUnlock()
-	
+	defer e.mu.RUnlock()
+	return sortedKeys(e.workflows)
+}
+
+// newInstanceID returns a random ID for a workflow instance.
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RunWorkflow executes a workflow from a given state. A new instance's data
+// is first validated against the workflow's input schema; invalid data fails
+// the instance with a *ValidationError before it starts, so listeners get a
+// WorkflowFailed event without a WorkflowStarted event before it. When
+// the instance completes or fails it is saved, with its trace, to the state
+// storage if one is set.
+func (e *WorkflowEngine) RunWorkflow(ctx context.Context, wfName string, state *WorkflowState) error {
+	wf, ok := e.Workflow(wfName)
 	if !ok {
 		return fmt.Errorf("workflow '%s' not found", wfName)
 	}
 
-	// Trigger workflow start event
-	for _, handler := range e.eventHandlers {
-		if err := handler.OnWorkflowStart(ctx, wfName, state); err != nil {
-			return fmt.Errorf("workflow start event handler failed: %w", err)
+	e.mu.RLock()
+	listeners := append([]EventListener(nil), e.listeners...)
+	e.mu.RUnlock()
+
+	err := e.runWorkflow(ctx, wf, state, listeners)
+	e.saveState(ctx, wfName, state)
+	return err
+}
+
+// saveState stores a finished or failed instance, with its trace, in the
+// state storage. Failing to store it is logged but doesn't fail the
+// instance.
+func (e *WorkflowEngine) saveState(ctx context.Context, wfName string, state *WorkflowState) {
+	e.mu.RLock()
+	stateStorage := e.stateStorage
+	e.mu.RUnlock()
+	if stateStorage == nil {
+		return
+	}
+
+	if err := stateStorage.SaveState(context.WithoutCancel(ctx), wfName, state); err != nil {
+		e.logger.Error("failed to save workflow state", "workflow", wfName, "instance_id", state.ID, "error", err)
+	}
+}
+
+// runWorkflow executes a workflow definition, which need not be registered,
+// notifying the given event listeners. It waits for a free slot if the
+// maximum number of concurrent executions is reached. A new instance is
+// given an ID, which is logged with every record about it.
+func (e *WorkflowEngine) runWorkflow(ctx context.Context, wf Workflow, state *WorkflowState, listeners []EventListener) (err error) {
+	wfName := wf.Name
+
+	if state.ID == "" {
+		state.ID = newInstanceID()
+	}
+	state.Workflow = wfName
+	ctx, span := e.tracer.Start(ctx, "workflow.run", SpanKindInternal, "workflow", wfName, "instance_id", state.ID)
+	defer func() {
+		span.SetAttributes("status", state.Status, "step", state.CurrentStep)
+		span.RecordError(err)
+		span.Finish()
+	}()
+
+	logger := e.logger.With("workflow", wfName, "instance_id", state.ID)
+	if span != nil {
+		logger = logger.With("trace_id", span.Context.TraceID.String())
+	}
+	ctx = contextWithLogger(ctx, logger)
+	defer func() {
+		if err != nil {
+			e.failWorkflow(ctx, wfName, state, listeners, err)
 		}
+	}()
+
+	if err := e.limiter.acquire(ctx); err != nil {
+		return err
+	}
+	defer e.limiter.release()
+
+	e.mu.RLock()
+	timeout := e.timeout
+	e.mu.RUnlock()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if state.CurrentStep == "" {
+		state.StartedAt = time.Now()
+		if err := wf.ValidateInput(state.Data); err != nil {
+			return err
+		}
+	}
+
+	// Trigger workflow start event
+	if err := e.notify(ctx, listeners, &WorkflowEvent{Kind: EventWorkflowStarted, Workflow: wfName, State: state}); err != nil {
+		return fmt.Errorf("workflow start event handler failed: %w", err)
 	}
 
+	state.Status = StatusRunning
+	state.Error = ""
+
 	// Set the initial step if the state is new.
 	if state.CurrentStep == "" {
 		state.CurrentStep = wf.StartStep
+		state.Path = []string{wf.StartStep}
+		if err := e.notify(ctx, listeners, &WorkflowEvent{Kind: EventStepEnter, Workflow: wfName, State: state, Step: state.CurrentStep}); err != nil {
+			return fmt.Errorf("step enter event handler failed: %w", err)
+		}
+		if err := e.runStepActions(ctx, &wf, state, state.CurrentStep, actionOnEnter); err != nil {
+			return err
+		}
 	}
+	logger.Debug("workflow running", "step", state.CurrentStep)
 
 	for {
 		// Check if context is cancelled
@@ -149,40 +429,374 @@ func (e *WorkflowEngine) RunWorkflow(ctx context.Context, wfName string, state *
 		}
 
 		if currentTransition == nil {
-			fmt.Printf("Workflow finished at step: %s\n", state.CurrentStep)
-			
+			logger.Info("workflow completed", "step", state.CurrentStep)
+			state.Status = StatusCompleted
+			state.FinishedAt = time.Now()
+
 			// Trigger workflow end event
-			for _, handler := range e.eventHandlers {
-				if err := handler.OnWorkflowEnd(ctx, wfName, state); err != nil {
-					return fmt.Errorf("workflow end event handler failed: %w", err)
-				}
+			if err := e.notify(ctx, listeners, &WorkflowEvent{Kind: EventWorkflowCompleted, Workflow: wfName, State: state, Step: state.CurrentStep}); err != nil {
+				return fmt.Errorf("workflow end event handler failed: %w", err)
 			}
-			
+
 			return nil
 		}
 
-		// Evaluate the rule.
-		ruleResult, err := e.ruleEngine.Evaluate(ctx, currentTransition.RuleName, state.Data)
+		// Evaluate the rule, recording the transition in the trace.
+		entry := TraceEntry{
+			FromStep:  state.CurrentStep,
+			RuleName:  currentTransition.Rule.String(),
+			Input:     CopyData(state.Data),
+			StartedAt: time.Now(),
+		}
+		transitionCtx, transitionSpan := e.tracer.Start(ctx, "workflow.transition", SpanKindInternal,
+			"from", entry.FromStep, "rule", entry.RuleName)
+		endTransition := func(err error) {
+			state.addTrace(entry, err)
+			transitionSpan.SetAttributes("to", entry.ToStep, "rule_result", entry.RuleResult)
+			transitionSpan.RecordError(err)
+			transitionSpan.Finish()
+		}
+
+		ruleTrace, diff, err := e.evaluateTransition(transitionCtx, currentTransition, state)
 		if err != nil {
-			return fmt.Errorf("failed to evaluate rule '%s': %w", currentTransition.RuleName, err)
+			err = fmt.Errorf("failed to evaluate rule '%s': %w", currentTransition.Rule, err)
+			endTransition(err)
+			e.notify(transitionCtx, listeners, &WorkflowEvent{Kind: EventRuleEvaluated, Workflow: wfName, State: state,
+				FromStep: entry.FromStep, Rule: entry.RuleName, Err: err})
+			return err
 		}
+		ruleResult := ruleTrace.Result
 
 		previousStep := state.CurrentStep
+		nextStep := currentTransition.FallbackStep
 		if ruleResult {
-			state.CurrentStep = currentTransition.ToStep
-			fmt.Printf("Transitioning from '%s' to '%s' (Rule '%s' passed)\n", currentTransition.FromStep, state.CurrentStep, currentTransition.RuleName)
-		} else {
-			state.CurrentStep = currentTransition.FallbackStep
-			fmt.Printf("Transitioning from '%s' to '%s' (Rule '%s' failed)\n", currentTransition.FromStep, state.CurrentStep, currentTransition.RuleName)
+			nextStep = currentTransition.ToStep
+		}
+		entry.ToStep = nextStep
+		entry.RuleResult = ruleResult
+		entry.RuleTrace = ruleTrace
+		entry.Diff = diff
+
+		if err := e.notify(transitionCtx, listeners, &WorkflowEvent{Kind: EventRuleEvaluated, Workflow: wfName, State: state,
+			FromStep: previousStep, ToStep: nextStep, Rule: entry.RuleName, RuleResult: ruleTrace}); err != nil {
+			err = fmt.Errorf("rule evaluated event handler failed: %w", err)
+			endTransition(err)
+			return err
+		}
+		if err := e.notify(transitionCtx, listeners, &WorkflowEvent{Kind: EventStepExit, Workflow: wfName, State: state, Step: previousStep}); err != nil {
+			err = fmt.Errorf("step exit event handler failed: %w", err)
+			endTransition(err)
+			return err
+		}
+		if err := e.runStepActions(transitionCtx, &wf, state, previousStep, actionOnExit); err != nil {
+			endTransition(err)
+			return err
+		}
+
+		state.CurrentStep = nextStep
+		state.Path = append(state.Path, nextStep)
+		logger.Debug("step transition",
+			"step", state.CurrentStep,
+			"from", previousStep,
+			"rule", currentTransition.Rule.String(),
+			"rule_result", ruleResult)
+
+		state.LastTransition = &TransitionRecord{
+			FromStep:   previousStep,
+			ToStep:     state.CurrentStep,
+			RuleName:   currentTransition.Rule.String(),
+			RuleResult: ruleResult,
+			RuleTrace:  ruleTrace,
+			Diff:       diff,
 		}
 
+		if err := e.notify(transitionCtx, listeners, &WorkflowEvent{Kind: EventStepEnter, Workflow: wfName, State: state, Step: state.CurrentStep}); err != nil {
+			err = fmt.Errorf("step enter event handler failed: %w", err)
+			endTransition(err)
+			return err
+		}
+		if err := e.runStepActions(transitionCtx, &wf, state, state.CurrentStep, actionOnEnter); err != nil {
+			endTransition(err)
+			return err
+		}
+		endTransition(nil)
+
 		// Trigger step transition event
-		for _, handler := range e.eventHandlers {
-			if err := handler.OnStepTransition(ctx, wfName, previousStep, state.CurrentStep, state); err != nil {
-				return fmt.Errorf("step transition event handler failed: %w", err)
+		if err := e.notify(ctx, listeners, &WorkflowEvent{Kind: EventStepTransition, Workflow: wfName, State: state,
+			FromStep: previousStep, ToStep: state.CurrentStep}); err != nil {
+			return fmt.Errorf("step transition event handler failed: %w", err)
+		}
+	}
+}
+
+// failWorkflow marks an instance as failed, logs the error and notifies the
+// listeners, with a WorkflowCancelled event if the instance was cancelled
+// or timed out.
+func (e *WorkflowEngine) failWorkflow(ctx context.Context, wfName string, state *WorkflowState, listeners []EventListener, err error) {
+	state.Status = StatusFailed
+	if state.Error == "" {
+		state.Error = err.Error()
+	}
+	state.FinishedAt = time.Now()
+
+	logger := LoggerFromContext(ctx)
+	logger.Error("workflow failed", "step", state.CurrentStep, "error", err)
+
+	event := &WorkflowEvent{Kind: EventWorkflowFailed, Workflow: wfName, State: state, Step: state.CurrentStep, Err: err}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		event.Kind = EventWorkflowCancelled
+	}
+	// Listeners are still told when the instance failed by timing out.
+	if herr := e.notify(context.WithoutCancel(ctx), listeners, event); herr != nil {
+		logger.Warn("workflow failure handler failed", "error", herr)
+	}
+}
+
+// notify sends an event to the listeners that want it, in order. Blocking
+// listeners are called directly and the first error is returned; async
+// listeners' events are queued in the outbox, or, without one or if
+// queueing fails, delivered directly with their errors logged. An event
+// ending the instance goes on to every listener whatever they return.
+func (e *WorkflowEngine) notify(ctx context.Context, listeners []EventListener, event *WorkflowEvent) error {
+	e.mu.RLock()
+	outbox := e.outbox
+	e.mu.RUnlock()
+	logger := LoggerFromContext(ctx)
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	var errs []error
+	for _, listener := range listeners {
+		if !wantsEvent(listener, event.Kind) {
+			continue
+		}
+
+		name, async := asyncName(listener)
+		switch {
+		case async:
+			if outbox != nil {
+				err := outbox.Enqueue(name, listener, event)
+				if err == nil {
+					continue
+				}
+				// Deliver the event now rather than lose it.
+				logger.Error("failed to queue event", "handler", name, "event", event.Kind, "error", err)
+			}
+			if err := listener.OnEvent(ctx, event); err != nil {
+				logger.Warn("async event handler failed", "handler", name, "event", event.Kind, "error", err)
+			}
+		default:
+			if err := listener.OnEvent(ctx, event); err != nil {
+				if !event.Ends() {
+					return err
+				}
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runStepActions runs the on_enter or on_exit actions declared for a step, in
+// order. The first failing action fails the instance: the error is recorded
+// on the state and returned as an *ActionError.
+func (e *WorkflowEngine) runStepActions(ctx context.Context, wf *Workflow, state *WorkflowState, stepName string, phase string) error {
+	step := wf.Step(stepName)
+	if step == nil {
+		return nil
+	}
+	ctx = contextWithLogger(ctx, LoggerFromContext(ctx).With("step", stepName))
+
+	actions := step.OnEnter
+	if phase == actionOnExit {
+		actions = step.OnExit
+	}
+
+	for _, action := range actions {
+		if err := e.runAction(ctx, action, state); err != nil {
+			actionErr := &ActionError{Step: stepName, Phase: phase, Action: action, Err: err}
+			state.Status = StatusFailed
+			state.Error = actionErr.Error()
+			return actionErr
+		}
+	}
+
+	return nil
+}
+
+// runAction resolves an action by name, preferring registered Go functions
+// over scripts run by the rule engine.
+func (e *WorkflowEngine) runAction(ctx context.Context, name string, state *WorkflowState) error {
+	if fn, ok := e.registry.Action(name); ok {
+		return fn(ctx, state)
+	}
+
+	e.mu.RLock()
+	runner, isRunner := e.ruleEngine.(ActionRunner)
+	e.mu.RUnlock()
+
+	if !isRunner {
+		return fmt.Errorf("action '%s' is not registered and the rule engine cannot run action scripts", name)
+	}
+	return runner.RunAction(ctx, name, state)
+}
+
+// evaluateTransition evaluates the rule spec guarding a transition and
+// returns the result of every rule it evaluated. For transitions marked as
+// mutating, changes made by the rules are merged into the state data and
+// returned as a diff.
+func (e *WorkflowEngine) evaluateTransition(ctx context.Context, t *Transition, state *WorkflowState) (*RuleResult, DataDiff, error) {
+	diff := make(DataDiff)
+	ctx = contextWithLogger(ctx, LoggerFromContext(ctx).With("step", t.FromStep))
+	result, err := e.evaluateRuleSpec(ctx, t.Rule, t.Mutates, state, diff)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(diff) == 0 {
+		diff = nil
+	}
+	return &result, diff, nil
+}
+
+// evaluateRuleSpec evaluates a rule spec with short-circuiting: all_of stops
+// at the first failing rule and any_of at the first passing one.
+func (e *WorkflowEngine) evaluateRuleSpec(ctx context.Context, spec RuleSpec, mutates bool, state *WorkflowState, diff DataDiff) (RuleResult, error) {
+	switch {
+	case spec.Not != nil:
+		child, err := e.evaluateRuleSpec(ctx, *spec.Not, mutates, state, diff)
+		if err != nil {
+			return RuleResult{}, err
+		}
+		return RuleResult{Rule: "not", Result: !child.Result, Children: []RuleResult{child}}, nil
+
+	case spec.AllOf != nil || spec.AnyOf != nil:
+		// all_of starts out true and any_of false; the first child that
+		// differs decides the result.
+		all := spec.AllOf != nil
+		specs, op := spec.AllOf, "all_of"
+		if !all {
+			specs, op = spec.AnyOf, "any_of"
+		}
+
+		result := RuleResult{Rule: op, Result: all}
+		for _, s := range specs {
+			child, err := e.evaluateRuleSpec(ctx, s, mutates, state, diff)
+			if err != nil {
+				return RuleResult{}, err
+			}
+			result.Children = append(result.Children, child)
+			if child.Result != all {
+				result.Result = !all
+				break
+			}
+		}
+		return result, nil
+
+	default:
+		passed, err := e.evaluateRule(ctx, spec.Name, mutates, state, diff)
+		if err != nil {
+			return RuleResult{}, err
+		}
+		return RuleResult{Rule: spec.Name, Result: passed}, nil
+	}
+}
+
+// evaluateRule evaluates a single named rule or inline expression, in a
+// rule.evaluate span. Changes made by mutating rules are merged into the
+// state data and added to diff.
+func (e *WorkflowEngine) evaluateRule(ctx context.Context, ruleName string, mutates bool, state *WorkflowState, diff DataDiff) (bool, error) {
+	ctx, span := e.tracer.Start(ctx, "rule.evaluate", SpanKindInternal, "rule", ruleName, "mutates", mutates)
+	result, err := e.runRule(ctx, ruleName, mutates, state, diff)
+	span.SetAttributes("rule_result", result)
+	span.RecordError(err)
+	span.Finish()
+	return result, err
+}
+
+// runRule evaluates a rule for evaluateRule.
+func (e *WorkflowEngine) runRule(ctx context.Context, ruleName string, mutates bool, state *WorkflowState, diff DataDiff) (bool, error) {
+	ruleEngine := e.RuleEngine()
+	if !mutates {
+		return ruleEngine.Evaluate(ctx, ruleName, state.Data)
+	}
+
+	mutator, ok := ruleEngine.(MutatingRuleEngine)
+	if !ok {
+		return false, fmt.Errorf("rule engine does not support mutating rules")
+	}
+
+	result, updated, err := mutator.EvaluateMutating(ctx, ruleName, state.Data)
+	if err != nil {
+		return false, err
+	}
+
+	if state.Data == nil {
+		state.Data = make(map[string]any)
+	}
+	changes := DiffData(state.Data, updated)
+	MergeData(state.Data, changes)
+
+	// Keep the value from before the transition when several rules change
+	// the same key.
+	for key, change := range changes {
+		if earlier, ok := diff[key]; ok {
+			change.Old = earlier.Old
+		}
+		diff[key] = change
+	}
+	return result, nil
+}
+
+// ValidateWorkflow checks a workflow definition as workflows loaded from the
+// workflows directory are checked, so that it can be registered with
+// RegisterWorkflow.
+func (e *WorkflowEngine) ValidateWorkflow(wf Workflow) error {
+	return e.validateWorkflow(&wf)
+}
+
+// validateWorkflow checks a workflow definition before it is registered.
+// It must have a name, and its input schema must be well formed. Every rule a transition refers to
+// must exist, and rules that can be checked, such as expressions, are
+// parsed and, if the workflow declares a data schema, type-checked against
+// it.
+func (e *WorkflowEngine) validateWorkflow(wf *Workflow) error {
+	if wf.Name == "" {
+		return fmt.Errorf("workflow name is required")
+	}
+	schema, err := wf.ExprSchema()
+	if err != nil {
+		return err
+	}
+	if wf.InputSchema != nil {
+		if err := wf.InputSchema.Check(); err != nil {
+			return err
+		}
+	}
+
+	ruleEngine := e.RuleEngine()
+	lookup, canLookup := ruleEngine.(RuleLookup)
+	checker, canCheck := ruleEngine.(RuleChecker)
+
+	for _, t := range wf.Transitions {
+		if err := t.Rule.Validate(); err != nil {
+			return fmt.Errorf("transition from '%s': %w", t.FromStep, err)
+		}
+
+		for _, name := range t.Rule.Leaves() {
+			if canLookup && !lookup.HasRule(name) {
+				return fmt.Errorf("transition from '%s': %w: %s", t.FromStep, ErrRuleNotFound, name)
+			}
+			if canCheck {
+				if err := checker.Check(name, schema); err != nil {
+					return fmt.Errorf("transition from '%s': %w", t.FromStep, err)
+				}
 			}
 		}
 	}
+
+	return nil
 }
 
 // loadWorkflows reads all YAML files from a directory and loads them as workflows.
@@ -199,6 +813,9 @@ func (e *WorkflowEngine) loadWorkflows(workflowsDir string) error {
 			if err != nil {
 				return fmt.Errorf("failed to load workflow from %s: %w", filePath, err)
 			}
+			if err := e.validateWorkflow(wf); err != nil {
+				return fmt.Errorf("invalid workflow in %s: %w", filePath, err)
+			}
 			e.RegisterWorkflow(*wf)
 		}
 	}
@@ -219,4 +836,4 @@ func (e *WorkflowEngine) loadWorkflowFromFile(filePath string) (*Workflow, error
 	}
 
 	return &wf, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Histogram buckets, in seconds, for instance durations and for the much
+// shorter rule evaluations and Lua pool waits.
+var (
+	instanceDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	ruleDurationBuckets     = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.5, 1}
+)
+
+// EngineMetrics are the metrics recorded about workflow executions, rule
+// evaluations and the Lua state pool. A nil *EngineMetrics records nothing.
+type EngineMetrics struct {
+	Registry            *Metrics
+	ExecutionsStarted   *CounterVec
+	ExecutionsCompleted *CounterVec
+	ExecutionsFailed    *CounterVec
+	Transitions         *CounterVec
+	InstanceDuration    *HistogramVec
+	RuleDuration        *HistogramVec
+	RuleFailures        *CounterVec
+	LuaPoolWait         *HistogramVec
+}
+
+// NewEngineMetrics registers the engine metrics in a registry
+func NewEngineMetrics(registry *Metrics) *EngineMetrics {
+	return &EngineMetrics{
+		Registry: registry,
+		ExecutionsStarted: registry.NewCounter("workflow_executions_started_total",
+			"Workflow executions started.", "workflow"),
+		ExecutionsCompleted: registry.NewCounter("workflow_executions_completed_total",
+			"Workflow executions that completed.", "workflow"),
+		ExecutionsFailed: registry.NewCounter("workflow_executions_failed_total",
+			"Workflow executions that failed, including those rejected by input validation.", "workflow"),
+		Transitions: registry.NewCounter("workflow_transitions_total",
+			"Step transitions taken.", "workflow", "from", "to"),
+		InstanceDuration: registry.NewHistogram("workflow_instance_duration_seconds",
+			"Time from the start of a workflow instance until it completed or failed.", instanceDurationBuckets, "workflow", "status"),
+		RuleDuration: registry.NewHistogram("workflow_rule_evaluation_duration_seconds",
+			"Time taken to evaluate a Lua rule, including waiting for a Lua state.", ruleDurationBuckets, "rule"),
+		RuleFailures: registry.NewCounter("workflow_rule_evaluation_failures_total",
+			"Lua rule evaluations that returned an error.", "rule"),
+		LuaPoolWait: registry.NewHistogram("workflow_lua_pool_wait_seconds",
+			"Time spent waiting for a Lua state from the pool.", ruleDurationBuckets),
+	}
+}
+
+// observeRule records the duration and outcome of a rule evaluation.
+func (m *EngineMetrics) observeRule(rule string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.RuleDuration.Observe(time.Since(start).Seconds(), rule)
+	if err != nil {
+		m.RuleFailures.Inc(rule)
+	}
+}
+
+// observePoolWait records the time taken to get a state from the Lua pool.
+func (m *EngineMetrics) observePoolWait(wait time.Duration) {
+	if m == nil {
+		return
+	}
+	m.LuaPoolWait.Observe(wait.Seconds())
+}
+
+// watchLuaPool registers gauges reporting the size and use of a Lua pool.
+func (m *EngineMetrics) watchLuaPool(pool *LuaStatePool) {
+	if m == nil {
+		return
+	}
+	m.Registry.NewGaugeFunc("workflow_lua_pool_states", "Lua states in the pool.", func() float64 {
+		return float64(pool.Size())
+	})
+	m.Registry.NewGaugeFunc("workflow_lua_pool_in_use", "Lua states taken from the pool.", func() float64 {
+		return float64(pool.InUse())
+	})
+	m.Registry.NewGaugeFunc("workflow_lua_pool_utilization", "Fraction of the Lua states taken from the pool.", func() float64 {
+		if pool.Size() == 0 {
+			return 0
+		}
+		return float64(pool.InUse()) / float64(pool.Size())
+	})
+}
+
+// MetricsEventHandler implements EventHandler and FailureHandler to count
+// workflow executions and transitions and time instances.
+type MetricsEventHandler struct {
+	metrics *EngineMetrics
+}
+
+// NewMetricsEventHandler creates a handler recording to the given metrics
+func NewMetricsEventHandler(metrics *EngineMetrics) *MetricsEventHandler {
+	return &MetricsEventHandler{metrics: metrics}
+}
+
+// OnWorkflowStart counts a started execution
+func (h *MetricsEventHandler) OnWorkflowStart(ctx context.Context, workflowName string, state *WorkflowState) error {
+	h.metrics.ExecutionsStarted.Inc(workflowName)
+	return nil
+}
+
+// OnWorkflowEnd counts a completed execution and records its duration
+func (h *MetricsEventHandler) OnWorkflowEnd(ctx context.Context, workflowName string, state *WorkflowState) error {
+	h.metrics.ExecutionsCompleted.Inc(workflowName)
+	h.observeDuration(workflowName, StatusCompleted, state)
+	return nil
+}
+
+// OnStepTransition counts a transition between two steps
+func (h *MetricsEventHandler) OnStepTransition(ctx context.Context, workflowName string, fromStep, toStep string, state *WorkflowState) error {
+	h.metrics.Transitions.Inc(workflowName, fromStep, toStep)
+	return nil
+}
+
+// OnWorkflowFailed counts a failed execution and records its duration
+func (h *MetricsEventHandler) OnWorkflowFailed(ctx context.Context, workflowName string, state *WorkflowState, err error) error {
+	h.metrics.ExecutionsFailed.Inc(workflowName)
+	h.observeDuration(workflowName, StatusFailed, state)
+	return nil
+}
+
+func (h *MetricsEventHandler) observeDuration(workflowName, status string, state *WorkflowState) {
+	if state.StartedAt.IsZero() || state.FinishedAt.IsZero() {
+		return
+	}
+	h.metrics.InstanceDuration.Observe(state.FinishedAt.Sub(state.StartedAt).Seconds(), workflowName, status)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFiles writes files, given by name and content, to a new
+// temporary directory and returns it.
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// newTestEngine creates an engine loading the given workflow and rule files.
+func newTestEngine(t *testing.T, opts EngineOptions, workflows, rules map[string]string) (*WorkflowEngine, error) {
+	t.Helper()
+	opts.WorkflowsDir = writeTestFiles(t, workflows)
+	opts.RulesDir = writeTestFiles(t, rules)
+	if opts.LuaPoolSize == 0 {
+		opts.LuaPoolSize = 2
+	}
+	engine, err := NewWorkflowEngine(opts)
+	if err == nil {
+		t.Cleanup(engine.luaPool.Close)
+	}
+	return engine, err
+}
+
+const goRuleWorkflow = `
+name: Signup
+start_step: start
+transitions:
+  - from: start
+    to: welcome
+    rule: has_email
+    fallback_to: ask_email
+`
+
+func TestLoadWorkflowWithGoRule(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterRule("has_email", func(ctx context.Context, data map[string]any) (bool, error) {
+		email, _ := data["email"].(string)
+		return email != "", nil
+	})
+
+	engine, err := newTestEngine(t, EngineOptions{Registry: registry}, map[string]string{"signup.yml": goRuleWorkflow}, nil)
+	if err != nil {
+		t.Fatalf("NewWorkflowEngine: %v", err)
+	}
+
+	state := &WorkflowState{Data: map[string]any{"email": "sam@example.com"}}
+	if err := engine.RunWorkflow(context.Background(), "Signup", state); err != nil {
+		t.Fatalf("RunWorkflow: %v", err)
+	}
+	if state.CurrentStep != "welcome" {
+		t.Errorf("instance ended in %q, want welcome", state.CurrentStep)
+	}
+}
+
+func TestLoadWorkflowValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		workflow string
+		rules    map[string]string
+		wantErr  string
+	}{
+		{
+			name:     "unknown rule",
+			workflow: goRuleWorkflow,
+			wantErr:  "rule not found: has_email",
+		},
+		{
+			name:     "rule file",
+			workflow: goRuleWorkflow,
+			rules:    map[string]string{"has_email.expr": `email != ""`},
+		},
+		{
+			name: "unknown rule in a combination",
+			workflow: `
+name: Signup
+start_step: start
+transitions:
+  - from: start
+    to: welcome
+    rule: {all_of: [pass, {not: has_email}]}
+    fallback_to: ask_email
+`,
+			wantErr: "rule not found: has_email",
+		},
+		{
+			name: "invalid combination",
+			workflow: `
+name: Signup
+start_step: start
+transitions:
+  - from: start
+    to: welcome
+    rule: {any_of: []}
+    fallback_to: ask_email
+`,
+			wantErr: "any_of() must list at least one rule",
+		},
+		{
+			name: "expression type error",
+			workflow: `
+name: Signup
+start_step: start
+data_schema:
+  age: number
+transitions:
+  - from: start
+    to: welcome
+    rule: age == "adult"
+    fallback_to: ask_email
+`,
+			wantErr: "cannot compare number with string",
+		},
+		{
+			name: "unknown schema type",
+			workflow: `
+name: Signup
+start_step: start
+data_schema:
+  age: years
+transitions:
+  - from: start
+    to: welcome
+    rule: pass
+    fallback_to: ask_email
+`,
+			wantErr: "data_schema field 'age'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestEngine(t, EngineOptions{}, map[string]string{"signup.yml": tt.workflow}, tt.rules)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewWorkflowEngine: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+			if strings.Contains(tt.wantErr, "rule not found") && !errors.Is(err, ErrRuleNotFound) {
+				t.Errorf("err = %v, want ErrRuleNotFound", err)
+			}
+		})
+	}
+}
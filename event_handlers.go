@@ -2,45 +2,59 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"log"
 )
 
-// LoggingEventHandler implements EventHandler to log workflow events
+// LoggingEventHandler implements EventHandler to log workflow events. Events
+// are logged with the logger carried by the context, which has the
+// workflow, instance id and step as fields.
 type LoggingEventHandler struct{}
 
 // OnWorkflowStart logs the start of a workflow
 func (l *LoggingEventHandler) OnWorkflowStart(ctx context.Context, workflowName string, state *WorkflowState) error {
-	log.Printf("Workflow '%s' started with data: %+v", workflowName, state.Data)
+	LoggerFromContext(ctx).Info("workflow started", "data", state.Data)
 	return nil
 }
 
 // OnWorkflowEnd logs the end of a workflow
 func (l *LoggingEventHandler) OnWorkflowEnd(ctx context.Context, workflowName string, state *WorkflowState) error {
-	log.Printf("Workflow '%s' ended at step: %s", workflowName, state.CurrentStep)
+	LoggerFromContext(ctx).Info("workflow ended", "step", state.CurrentStep)
 	return nil
 }
 
 // OnStepTransition logs a step transition
 func (l *LoggingEventHandler) OnStepTransition(ctx context.Context, workflowName string, fromStep, toStep string, state *WorkflowState) error {
-	log.Printf("Workflow '%s' transitioned from '%s' to '%s'", workflowName, fromStep, toStep)
+	logger := LoggerFromContext(ctx).With("step", toStep)
+	logger.Info("step transition", "from", fromStep)
+	if state.LastTransition != nil && len(state.LastTransition.Diff) > 0 {
+		logger.Info("data changed", "rule", state.LastTransition.RuleName, "diff", state.LastTransition.Diff)
+	}
 	return nil
 }
 
-// ValidationErrorHandler implements EventHandler to validate workflow data
-type ValidationErrorHandler struct{}
+// ValidationErrorHandler implements EventHandler to check that workflow data
+// contains a set of required fields. Workflows that need more than that
+// should declare an input_schema instead.
+type ValidationErrorHandler struct {
+	RequiredFields []string
+}
+
+// NewValidationErrorHandler creates a handler requiring the given fields
+func NewValidationErrorHandler(requiredFields ...string) *ValidationErrorHandler {
+	return &ValidationErrorHandler{RequiredFields: requiredFields}
+}
 
 // OnWorkflowStart validates the workflow data
 func (v *ValidationErrorHandler) OnWorkflowStart(ctx context.Context, workflowName string, state *WorkflowState) error {
-	// Example validation - check if required fields are present
-	requiredFields := []string{"name", "age"}
-	
-	for _, field := range requiredFields {
+	var fields []FieldError
+	for _, field := range v.RequiredFields {
 		if _, exists := state.Data[field]; !exists {
-			return fmt.Errorf("required field '%s' is missing from workflow data", field)
+			fields = append(fields, FieldError{Field: field, Message: "is required"})
 		}
 	}
-	
+
+	if len(fields) > 0 {
+		return &ValidationError{Workflow: workflowName, Fields: fields}
+	}
 	return nil
 }
 
@@ -52,4 +66,4 @@ func (v *ValidationErrorHandler) OnWorkflowEnd(ctx context.Context, workflowName
 // OnStepTransition does nothing for validation
 func (v *ValidationErrorHandler) OnStepTransition(ctx context.Context, workflowName string, fromStep, toStep string, state *WorkflowState) error {
 	return nil
-}
\ No newline at end of file
+}
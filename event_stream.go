@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// eventStreamBuffer is the number of events a subscriber can fall behind by
+// before it is dropped.
+const eventStreamBuffer = 256
+
+// EventBroadcaster implements EventHandler and FailureHandler to fan
+// workflow events out to live subscribers, such as the clients of the event
+// stream. Events have the same form as webhook events. Publishing never
+// blocks a workflow: a subscriber that falls eventStreamBuffer events
+// behind is dropped, closing its channel.
+type EventBroadcaster struct {
+	subscribers map[*EventSubscription]struct{}
+	closed      bool
+	mu          sync.Mutex
+}
+
+// EventSubscription receives the events of one subscriber on Events, which
+// is closed when the subscriber is dropped or the broadcaster is closed.
+type EventSubscription struct {
+	Events <-chan *WebhookEvent
+
+	events     chan *WebhookEvent
+	workflow   string
+	instanceID string
+	dropped    bool
+}
+
+// Dropped reports whether the subscription was closed because the
+// subscriber fell behind. It is only meaningful once Events is closed.
+func (s *EventSubscription) Dropped() bool {
+	return s.dropped
+}
+
+// NewEventBroadcaster creates a broadcaster without subscribers.
+func NewEventBroadcaster() *EventBroadcaster {
+	return &EventBroadcaster{subscribers: make(map[*EventSubscription]struct{})}
+}
+
+// Subscribe adds a subscriber to the events of a workflow and instance; an
+// empty workflow or instance ID matches any.
+func (b *EventBroadcaster) Subscribe(workflow, instanceID string) *EventSubscription {
+	events := make(chan *WebhookEvent, eventStreamBuffer)
+	sub := &EventSubscription{Events: events, events: events, workflow: workflow, instanceID: instanceID}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(events)
+		return sub
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes a subscriber and closes its channel, if the
+// broadcaster hasn't already.
+func (b *EventBroadcaster) Unsubscribe(sub *EventSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Subscribers returns the number of subscribers.
+func (b *EventBroadcaster) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// Close removes every subscriber, closing their channels, so that streams
+// end when the server shuts down. Later subscriptions are closed at once.
+func (b *EventBroadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publish sends an event to the subscribers that want it. The event is only
+// built when there are any.
+func (b *EventBroadcaster) publish(eventType, workflowName, fromStep, toStep string, state *WorkflowState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subscribers) == 0 {
+		return
+	}
+
+	var event *WebhookEvent
+	for sub := range b.subscribers {
+		if (sub.workflow != "" && sub.workflow != workflowName) || (sub.instanceID != "" && sub.instanceID != state.ID) {
+			continue
+		}
+		if event == nil {
+			event = newWebhookEvent(eventType, workflowName, state)
+			event.FromStep, event.ToStep = fromStep, toStep
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped = true
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// OnWorkflowStart publishes a workflow.started event
+func (b *EventBroadcaster) OnWorkflowStart(ctx context.Context, workflowName string, state *WorkflowState) error {
+	b.publish(WebhookWorkflowStarted, workflowName, "", "", state)
+	return nil
+}
+
+// OnWorkflowEnd publishes a workflow.completed event
+func (b *EventBroadcaster) OnWorkflowEnd(ctx context.Context, workflowName string, state *WorkflowState) error {
+	b.publish(WebhookWorkflowCompleted, workflowName, "", "", state)
+	return nil
+}
+
+// OnStepTransition publishes a step.transition event
+func (b *EventBroadcaster) OnStepTransition(ctx context.Context, workflowName string, fromStep, toStep string, state *WorkflowState) error {
+	b.publish(WebhookStepTransition, workflowName, fromStep, toStep, state)
+	return nil
+}
+
+// OnWorkflowFailed publishes a workflow.failed event
+func (b *EventBroadcaster) OnWorkflowFailed(ctx context.Context, workflowName string, state *WorkflowState, err error) error {
+	b.publish(WebhookWorkflowFailed, workflowName, "", "", state)
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// EventKind identifies the kind of a WorkflowEvent.
+type EventKind string
+
+// Kinds of workflow events, in the order an instance produces them. A
+// transition produces RuleEvaluated, StepExit for the step left, StepEnter
+// for the step entered and then StepTransition; StepEnter and StepExit come
+// before the step's on_enter and on_exit actions. An instance ends with
+// WorkflowCompleted, WorkflowFailed, or WorkflowCancelled when its context
+// is cancelled or it times out.
+const (
+	EventWorkflowStarted   EventKind = "workflow_started"
+	EventStepEnter         EventKind = "step_enter"
+	EventRuleEvaluated     EventKind = "rule_evaluated"
+	EventStepExit          EventKind = "step_exit"
+	EventStepTransition    EventKind = "step_transition"
+	EventWorkflowCompleted EventKind = "workflow_completed"
+	EventWorkflowFailed    EventKind = "workflow_failed"
+	EventWorkflowCancelled EventKind = "workflow_cancelled"
+)
+
+// WorkflowEvent describes something that happened to a workflow instance.
+// Fields that don't apply to the event's kind are left empty: Step is the
+// step entered, exited or ended in, FromStep and ToStep are set for
+// RuleEvaluated and StepTransition, Rule and RuleResult for RuleEvaluated,
+// and Err for WorkflowFailed, WorkflowCancelled and a RuleEvaluated whose
+// rule failed. State is the instance's state, which blocking listeners may
+// inspect but must not keep, as the instance carries on changing it.
+type WorkflowEvent struct {
+	Kind       EventKind
+	Workflow   string
+	State      *WorkflowState
+	Time       time.Time
+	Step       string
+	FromStep   string
+	ToStep     string
+	Rule       string
+	RuleResult *RuleResult
+	Err        error
+}
+
+// Ends reports whether the event ends the instance.
+func (e *WorkflowEvent) Ends() bool {
+	return e.Kind == EventWorkflowCompleted || e.Kind == EventWorkflowFailed || e.Kind == EventWorkflowCancelled
+}
+
+// EventListenerFunc adapts a function to an EventListener.
+type EventListenerFunc func(ctx context.Context, event *WorkflowEvent) error
+
+// OnEvent calls f
+func (f EventListenerFunc) OnEvent(ctx context.Context, event *WorkflowEvent) error {
+	return f(ctx, event)
+}
+
+// handlerListener adapts an EventHandler, and a FailureHandler if it is
+// one, to an EventListener. A cancelled instance is reported to the
+// FailureHandler as failed.
+type handlerListener struct {
+	handler EventHandler
+}
+
+// ListenerForHandler returns an EventListener calling an EventHandler's
+// methods for the events it has them for. Listeners are returned as they
+// are.
+func ListenerForHandler(handler EventHandler) EventListener {
+	if listener, ok := handler.(EventListener); ok {
+		return listener
+	}
+	return &handlerListener{handler: handler}
+}
+
+// WantsEvent reports whether the handler has a method for the kind
+func (l *handlerListener) WantsEvent(kind EventKind) bool {
+	switch kind {
+	case EventWorkflowStarted, EventWorkflowCompleted, EventStepTransition:
+		return true
+	case EventWorkflowFailed, EventWorkflowCancelled:
+		_, ok := l.handler.(FailureHandler)
+		return ok
+	}
+	return false
+}
+
+// OnEvent calls the handler method for the event
+func (l *handlerListener) OnEvent(ctx context.Context, event *WorkflowEvent) error {
+	switch event.Kind {
+	case EventWorkflowStarted:
+		return l.handler.OnWorkflowStart(ctx, event.Workflow, event.State)
+	case EventWorkflowCompleted:
+		return l.handler.OnWorkflowEnd(ctx, event.Workflow, event.State)
+	case EventStepTransition:
+		return l.handler.OnStepTransition(ctx, event.Workflow, event.FromStep, event.ToStep, event.State)
+	case EventWorkflowFailed, EventWorkflowCancelled:
+		if fh, ok := l.handler.(FailureHandler); ok {
+			return fh.OnWorkflowFailed(ctx, event.Workflow, event.State, event.Err)
+		}
+	}
+	return nil
+}
+
+// asyncName returns the name of a listener, or of the handler it adapts,
+// that implements AsyncHandler.
+func asyncName(listener EventListener) (string, bool) {
+	var async AsyncHandler
+	var ok bool
+	if l, adapted := listener.(*handlerListener); adapted {
+		async, ok = l.handler.(AsyncHandler)
+	} else {
+		async, ok = listener.(AsyncHandler)
+	}
+	if !ok {
+		return "", false
+	}
+	return async.HandlerName(), true
+}
+
+// wantsEvent reports whether a listener wants events of a kind.
+func wantsEvent(listener EventListener, kind EventKind) bool {
+	filter, ok := listener.(EventFilter)
+	return !ok || filter.WantsEvent(kind)
+}
@@ -0,0 +1,1009 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expressions are small CEL-style boolean rules such as
+//
+//	age >= 18 && customer_type in ["premium", "gold"]
+//
+// They support number, string, boolean, null and list literals, field access
+// with '.', indexing with '[]', the operators ! - * / % + < <= > >= == != in
+// && || and a handful of functions (see exprFunctions). Functions can also be
+// called on their first argument: name.startsWith("A").
+
+// ExprType is the static type of an expression or data field.
+type ExprType string
+
+// Expression types. TypeAny matches every type and is used for values whose
+// type is not known until evaluation.
+const (
+	TypeAny    ExprType = "any"
+	TypeBool   ExprType = "bool"
+	TypeNumber ExprType = "number"
+	TypeString ExprType = "string"
+	TypeList   ExprType = "list"
+	TypeMap    ExprType = "map"
+	TypeNull   ExprType = "null"
+)
+
+// ExprSchema maps top-level data fields to their types.
+type ExprSchema map[string]ExprType
+
+// ParseExprType converts a type name used in workflow schemas to an ExprType.
+func ParseExprType(name string) (ExprType, error) {
+	switch strings.ToLower(name) {
+	case "any", "":
+		return TypeAny, nil
+	case "bool", "boolean":
+		return TypeBool, nil
+	case "number", "integer", "int", "float":
+		return TypeNumber, nil
+	case "string":
+		return TypeString, nil
+	case "list", "array":
+		return TypeList, nil
+	case "map", "object":
+		return TypeMap, nil
+	default:
+		return "", fmt.Errorf("unknown type '%s'", name)
+	}
+}
+
+// Expr is a parsed expression.
+type Expr struct {
+	source string
+	root   exprNode
+}
+
+// ParseExpr parses an expression.
+func ParseExpr(source string) (*Expr, error) {
+	tokens, err := lexExpr(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", tok.text, tok.pos)
+	}
+
+	return &Expr{source: source, root: root}, nil
+}
+
+// String returns the expression source.
+func (x *Expr) String() string {
+	return x.source
+}
+
+// Check type-checks the expression against a schema and verifies that it
+// produces a boolean. With a nil schema every field has type TypeAny; with a
+// schema, fields it does not declare are reported as errors.
+func (x *Expr) Check(schema ExprSchema) error {
+	t, err := x.root.check(schema)
+	if err != nil {
+		return err
+	}
+	if !typeMatches(t, TypeBool) {
+		return fmt.Errorf("expression produces %s, not bool", t)
+	}
+	return nil
+}
+
+// EvalBool evaluates the expression against data and returns its result,
+// which must be a boolean.
+func (x *Expr) EvalBool(data map[string]any) (bool, error) {
+	v, err := x.root.eval(data)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression produced %s, not bool", typeOfValue(v))
+	}
+	return b, nil
+}
+
+// Lexer
+
+type exprTokenKind int
+
+const (
+	tokEOF exprTokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	pos  int
+}
+
+// exprOperators lists operators longest first so that "<=" wins over "<".
+var exprOperators = []string{
+	"&&", "||", "==", "!=", "<=", ">=",
+	"<", ">", "+", "-", "*", "/", "%", "!", "(", ")", "[", "]", ",", ".",
+}
+
+func lexExpr(source string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{tokNumber, string(runes[start:i]), start})
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string at position %d", start)
+				}
+				if runes[i] == r {
+					i++
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					switch runes[i] {
+					case 'n':
+						sb.WriteRune('\n')
+					case 't':
+						sb.WriteRune('\t')
+					default:
+						sb.WriteRune(runes[i])
+					}
+					continue
+				}
+				sb.WriteRune(runes[i])
+			}
+			tokens = append(tokens, exprToken{tokString, sb.String(), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, exprToken{tokIdent, string(runes[start:i]), start})
+		default:
+			matched := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, exprToken{tokOp, op, i})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", r, i)
+			}
+		}
+	}
+
+	return append(tokens, exprToken{tokEOF, "end of expression", len(runes)}), nil
+}
+
+// Parser
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is one of the given operators or
+// keywords.
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp && tok.kind != tokIdent {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		tok := p.peek()
+		return fmt.Errorf("expected '%s' but found '%s' at position %d", op, tok.text, tok.pos)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseBinary(p.parseRelation, "&&")
+}
+
+func (p *exprParser) parseRelation() (exprNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "in"); ok {
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &exprBinary{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+// parseBinary parses a left-associative chain of the given operators.
+func (p *exprParser) parseBinary(operand func() (exprNode, error), ops ...string) (exprNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if op, ok := p.accept("!", "-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{op: op, operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.peek().text == "." && p.peek().kind == tokOp:
+			p.next()
+			tok := p.next()
+			if tok.kind != tokIdent {
+				return nil, fmt.Errorf("expected field name but found '%s' at position %d", tok.text, tok.pos)
+			}
+			if p.peek().text == "(" && p.peek().kind == tokOp {
+				// Receiver-style call: x.fn(args) is fn(x, args).
+				args, err := p.parseArgs()
+				if err != nil {
+					return nil, err
+				}
+				node, err = newExprCall(tok.text, append([]exprNode{node}, args...))
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			node = &exprSelect{operand: node, field: tok.text}
+		case p.peek().text == "[" && p.peek().kind == tokOp:
+			p.next()
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			node = &exprIndex{operand: node, index: index}
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at position %d", tok.text, tok.pos)
+		}
+		return &exprLiteral{value: n}, nil
+	case tokString:
+		return &exprLiteral{value: tok.text}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return &exprLiteral{value: true}, nil
+		case "false":
+			return &exprLiteral{value: false}, nil
+		case "null":
+			return &exprLiteral{value: nil}, nil
+		}
+		if p.peek().text == "(" && p.peek().kind == tokOp {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return newExprCall(tok.text, args)
+		}
+		return &exprIdent{name: tok.text}, nil
+	case tokOp:
+		switch tok.text {
+		case "(":
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		case "[":
+			var items []exprNode
+			if _, ok := p.accept("]"); ok {
+				return &exprList{items: items}, nil
+			}
+			for {
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+				if _, ok := p.accept(","); !ok {
+					break
+				}
+			}
+			return &exprList{items: items}, p.expect("]")
+		}
+	}
+	return nil, fmt.Errorf("unexpected '%s' at position %d", tok.text, tok.pos)
+}
+
+func (p *exprParser) parseArgs() ([]exprNode, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []exprNode
+	if _, ok := p.accept(")"); ok {
+		return args, nil
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if _, ok := p.accept(","); !ok {
+			break
+		}
+	}
+	return args, p.expect(")")
+}
+
+// AST
+
+type exprNode interface {
+	check(schema ExprSchema) (ExprType, error)
+	eval(data map[string]any) (any, error)
+}
+
+type exprLiteral struct {
+	value any
+}
+
+func (n *exprLiteral) check(ExprSchema) (ExprType, error) {
+	return typeOfValue(n.value), nil
+}
+
+func (n *exprLiteral) eval(map[string]any) (any, error) {
+	return n.value, nil
+}
+
+type exprIdent struct {
+	name string
+}
+
+func (n *exprIdent) check(schema ExprSchema) (ExprType, error) {
+	if schema == nil {
+		return TypeAny, nil
+	}
+	t, ok := schema[n.name]
+	if !ok {
+		return "", fmt.Errorf("unknown field '%s'", n.name)
+	}
+	return t, nil
+}
+
+func (n *exprIdent) eval(data map[string]any) (any, error) {
+	return normalizeValue(data[n.name]), nil
+}
+
+type exprSelect struct {
+	operand exprNode
+	field   string
+}
+
+func (n *exprSelect) check(schema ExprSchema) (ExprType, error) {
+	t, err := n.operand.check(schema)
+	if err != nil {
+		return "", err
+	}
+	if !typeMatches(t, TypeMap) {
+		return "", fmt.Errorf("cannot select field '%s' from %s", n.field, t)
+	}
+	return TypeAny, nil
+}
+
+func (n *exprSelect) eval(data map[string]any) (any, error) {
+	v, err := n.operand.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	switch m := v.(type) {
+	case map[string]any:
+		return normalizeValue(m[n.field]), nil
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("cannot select field '%s' from %s", n.field, typeOfValue(v))
+	}
+}
+
+type exprIndex struct {
+	operand exprNode
+	index   exprNode
+}
+
+func (n *exprIndex) check(schema ExprSchema) (ExprType, error) {
+	t, err := n.operand.check(schema)
+	if err != nil {
+		return "", err
+	}
+	it, err := n.index.check(schema)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case t == TypeAny:
+	case t == TypeList && typeMatches(it, TypeNumber):
+	case t == TypeMap && typeMatches(it, TypeString):
+	default:
+		return "", fmt.Errorf("cannot index %s with %s", t, it)
+	}
+	return TypeAny, nil
+}
+
+func (n *exprIndex) eval(data map[string]any) (any, error) {
+	v, err := n.operand.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch container := v.(type) {
+	case []any:
+		i, ok := index.(float64)
+		if !ok || i != math.Trunc(i) {
+			return nil, fmt.Errorf("list index must be a whole number")
+		}
+		if i < 0 || int(i) >= len(container) {
+			return nil, nil
+		}
+		return container[int(i)], nil
+	case map[string]any:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be a string")
+		}
+		return container[key], nil
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("cannot index %s", typeOfValue(v))
+	}
+}
+
+type exprList struct {
+	items []exprNode
+}
+
+func (n *exprList) check(schema ExprSchema) (ExprType, error) {
+	for _, item := range n.items {
+		if _, err := item.check(schema); err != nil {
+			return "", err
+		}
+	}
+	return TypeList, nil
+}
+
+func (n *exprList) eval(data map[string]any) (any, error) {
+	items := make([]any, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(data)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+type exprUnary struct {
+	op      string
+	operand exprNode
+}
+
+func (n *exprUnary) check(schema ExprSchema) (ExprType, error) {
+	t, err := n.operand.check(schema)
+	if err != nil {
+		return "", err
+	}
+	want := TypeBool
+	if n.op == "-" {
+		want = TypeNumber
+	}
+	if !typeMatches(t, want) {
+		return "", fmt.Errorf("operator '%s' expects %s, got %s", n.op, want, t)
+	}
+	return want, nil
+}
+
+func (n *exprUnary) eval(data map[string]any) (any, error) {
+	v, err := n.operand.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	switch val := v.(type) {
+	case bool:
+		if n.op == "!" {
+			return !val, nil
+		}
+	case float64:
+		if n.op == "-" {
+			return -val, nil
+		}
+	}
+	return nil, fmt.Errorf("operator '%s' cannot be applied to %s", n.op, typeOfValue(v))
+}
+
+type exprBinary struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *exprBinary) check(schema ExprSchema) (ExprType, error) {
+	lt, err := n.left.check(schema)
+	if err != nil {
+		return "", err
+	}
+	rt, err := n.right.check(schema)
+	if err != nil {
+		return "", err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		if !typeMatches(lt, TypeBool) || !typeMatches(rt, TypeBool) {
+			return "", fmt.Errorf("operator '%s' expects bool operands, got %s and %s", n.op, lt, rt)
+		}
+		return TypeBool, nil
+	case "==", "!=":
+		if !typeMatches(lt, rt) && lt != TypeNull && rt != TypeNull {
+			return "", fmt.Errorf("cannot compare %s with %s", lt, rt)
+		}
+		return TypeBool, nil
+	case "<", "<=", ">", ">=":
+		if !typeMatches(lt, rt) || !(typeMatches(lt, TypeNumber) || typeMatches(lt, TypeString)) {
+			return "", fmt.Errorf("operator '%s' cannot compare %s with %s", n.op, lt, rt)
+		}
+		return TypeBool, nil
+	case "in":
+		if !typeMatches(rt, TypeList) && !typeMatches(rt, TypeMap) {
+			return "", fmt.Errorf("operator 'in' expects a list or map, got %s", rt)
+		}
+		return TypeBool, nil
+	case "+":
+		if !typeMatches(lt, rt) {
+			return "", fmt.Errorf("operator '+' cannot combine %s and %s", lt, rt)
+		}
+		switch {
+		case lt == TypeAny:
+			return rt, nil
+		case lt == TypeNumber || lt == TypeString || lt == TypeList:
+			return lt, nil
+		}
+		return "", fmt.Errorf("operator '+' cannot be applied to %s", lt)
+	default:
+		if !typeMatches(lt, TypeNumber) || !typeMatches(rt, TypeNumber) {
+			return "", fmt.Errorf("operator '%s' expects number operands, got %s and %s", n.op, lt, rt)
+		}
+		return TypeNumber, nil
+	}
+}
+
+func (n *exprBinary) eval(data map[string]any) (any, error) {
+	left, err := n.left.eval(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical operators short-circuit.
+	if n.op == "&&" || n.op == "||" {
+		lb, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator '%s' expects bool operands, got %s", n.op, typeOfValue(left))
+		}
+		if (n.op == "&&" && !lb) || (n.op == "||" && lb) {
+			return lb, nil
+		}
+		right, err := n.right.eval(data)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator '%s' expects bool operands, got %s", n.op, typeOfValue(right))
+		}
+		return rb, nil
+	}
+
+	right, err := n.right.eval(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+	case "<", "<=", ">", ">=":
+		// Missing values cannot be ordered: 'age >= 18' on a record without
+		// an age is an error, not false.
+		cmp, err := compareValues(left, right)
+		if err != nil {
+			return nil, fmt.Errorf("operator '%s': %w", n.op, err)
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	case "in":
+		switch container := right.(type) {
+		case []any:
+			for _, item := range container {
+				if valuesEqual(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			key, ok := left.(string)
+			if !ok {
+				return false, nil
+			}
+			_, exists := container[key]
+			return exists, nil
+		case nil:
+			return false, nil
+		default:
+			return nil, fmt.Errorf("operator 'in' expects a list or map, got %s", typeOfValue(right))
+		}
+	case "+":
+		switch l := left.(type) {
+		case float64:
+			if r, ok := right.(float64); ok {
+				return l + r, nil
+			}
+		case string:
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		case []any:
+			if r, ok := right.([]any); ok {
+				return append(append([]any{}, l...), r...), nil
+			}
+		}
+		return nil, fmt.Errorf("operator '+' cannot combine %s and %s", typeOfValue(left), typeOfValue(right))
+	default:
+		l, lok := left.(float64)
+		r, rok := right.(float64)
+		if !lok || !rok {
+			return nil, fmt.Errorf("operator '%s' expects number operands, got %s and %s", n.op, typeOfValue(left), typeOfValue(right))
+		}
+		switch n.op {
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return l / r, nil
+		default:
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return math.Mod(l, r), nil
+		}
+	}
+}
+
+// Functions
+
+// exprFunction describes a built-in function: its parameter and result
+// types for checking, and its implementation.
+type exprFunction struct {
+	params []ExprType
+	result ExprType
+	call   func(args []any) (any, error)
+}
+
+var exprFunctions = map[string]exprFunction{
+	"size": {[]ExprType{TypeAny}, TypeNumber, func(args []any) (any, error) {
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []any:
+			return float64(len(v)), nil
+		case map[string]any:
+			return float64(len(v)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return nil, fmt.Errorf("size() cannot be applied to %s", typeOfValue(args[0]))
+	}},
+	"matches": {[]ExprType{TypeString, TypeString}, TypeBool, func(args []any) (any, error) {
+		s, pattern, err := twoStrings("matches", args)
+		if err != nil {
+			return nil, err
+		}
+		re, err := compileHostRegex(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+		return re.MatchString(s), nil
+	}},
+	"startsWith": {[]ExprType{TypeString, TypeString}, TypeBool, func(args []any) (any, error) {
+		s, prefix, err := twoStrings("startsWith", args)
+		return strings.HasPrefix(s, prefix), err
+	}},
+	"endsWith": {[]ExprType{TypeString, TypeString}, TypeBool, func(args []any) (any, error) {
+		s, suffix, err := twoStrings("endsWith", args)
+		return strings.HasSuffix(s, suffix), err
+	}},
+	"contains": {[]ExprType{TypeString, TypeString}, TypeBool, func(args []any) (any, error) {
+		s, sub, err := twoStrings("contains", args)
+		return strings.Contains(s, sub), err
+	}},
+	"lower": {[]ExprType{TypeString}, TypeString, func(args []any) (any, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("lower() expects a string")
+		}
+		return strings.ToLower(s), nil
+	}},
+	"upper": {[]ExprType{TypeString}, TypeString, func(args []any) (any, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("upper() expects a string")
+		}
+		return strings.ToUpper(s), nil
+	}},
+	"abs": {[]ExprType{TypeNumber}, TypeNumber, func(args []any) (any, error) {
+		n, ok := args[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("abs() expects a number")
+		}
+		return math.Abs(n), nil
+	}},
+}
+
+func twoStrings(fn string, args []any) (string, string, error) {
+	a, aok := args[0].(string)
+	b, bok := args[1].(string)
+	if !aok || !bok {
+		return "", "", fmt.Errorf("%s() expects string arguments", fn)
+	}
+	return a, b, nil
+}
+
+type exprCall struct {
+	name string
+	fn   exprFunction
+	args []exprNode
+}
+
+// exprHas implements has(field), which reports whether a field is present
+// without tripping over undeclared or missing fields.
+type exprHas struct {
+	path []string
+}
+
+func newExprCall(name string, args []exprNode) (exprNode, error) {
+	if name == "has" {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("has() expects 1 argument")
+		}
+		path, ok := fieldPath(args[0])
+		if !ok {
+			return nil, fmt.Errorf("has() expects a field such as has(address.country)")
+		}
+		return &exprHas{path: path}, nil
+	}
+
+	fn, ok := exprFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s'", name)
+	}
+	if len(args) != len(fn.params) {
+		return nil, fmt.Errorf("%s() expects %d argument(s), got %d", name, len(fn.params), len(args))
+	}
+	return &exprCall{name: name, fn: fn, args: args}, nil
+}
+
+// fieldPath returns the field names of an identifier or selection chain.
+func fieldPath(n exprNode) ([]string, bool) {
+	switch node := n.(type) {
+	case *exprIdent:
+		return []string{node.name}, true
+	case *exprSelect:
+		path, ok := fieldPath(node.operand)
+		return append(path, node.field), ok
+	}
+	return nil, false
+}
+
+func (n *exprCall) check(schema ExprSchema) (ExprType, error) {
+	for i, arg := range n.args {
+		t, err := arg.check(schema)
+		if err != nil {
+			return "", err
+		}
+		if !typeMatches(t, n.fn.params[i]) {
+			return "", fmt.Errorf("%s() argument %d must be %s, got %s", n.name, i+1, n.fn.params[i], t)
+		}
+	}
+	return n.fn.result, nil
+}
+
+func (n *exprCall) eval(data map[string]any) (any, error) {
+	args := make([]any, 0, len(n.args))
+	for _, arg := range n.args {
+		v, err := arg.eval(data)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return n.fn.call(args)
+}
+
+func (n *exprHas) check(ExprSchema) (ExprType, error) {
+	return TypeBool, nil
+}
+
+func (n *exprHas) eval(data map[string]any) (any, error) {
+	var current any = data
+	for _, field := range n.path {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return false, nil
+		}
+		if current, ok = m[field]; !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Helpers
+
+// typeMatches reports whether a value of type got can be used where want is
+// expected.
+func typeMatches(got, want ExprType) bool {
+	return got == want || got == TypeAny || want == TypeAny
+}
+
+// typeOfValue returns the ExprType of a runtime value.
+func typeOfValue(v any) ExprType {
+	switch normalizeValue(v).(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBool
+	case float64:
+		return TypeNumber
+	case string:
+		return TypeString
+	case []any:
+		return TypeList
+	case map[string]any:
+		return TypeMap
+	default:
+		return TypeAny
+	}
+}
+
+// compareValues orders two numbers or two strings.
+func compareValues(a, b any) (int, error) {
+	switch l := a.(type) {
+	case float64:
+		if r, ok := b.(float64); ok {
+			switch {
+			case l < r:
+				return -1, nil
+			case l > r:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if r, ok := b.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", typeOfValue(a), typeOfValue(b))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ruleNamePattern matches rule names: letters, digits, '_', '-' and '.', as
+// in "credit-check" or "v1.approve". Anything else in a transition's rule
+// field is treated as an inline expression.
+var ruleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// IsInlineExpression reports whether a transition's rule field holds an
+// expression such as "age >= 18" rather than the name of a rule.
+func IsInlineExpression(rule string) bool {
+	return !ruleNamePattern.MatchString(rule)
+}
+
+// ExprRuleEngine implements the RuleEngine interface for expression rules,
+// written inline in a transition or stored as .expr files in the rules
+// directory.
+type ExprRuleEngine struct {
+	rulesDir string
+	named    map[string]*Expr
+	inline   map[string]*Expr
+	files    map[string]*compiledExpr
+	cacheTTL time.Duration
+	mu       sync.RWMutex
+}
+
+// compiledExpr is a parsed .expr file together with its modification time.
+type compiledExpr struct {
+	expr       *Expr
+	modTime    time.Time
+	compiledAt time.Time
+}
+
+// NewExprRuleEngine creates a new expression rule engine
+func NewExprRuleEngine(rulesDir string) *ExprRuleEngine {
+	return &ExprRuleEngine{
+		rulesDir: rulesDir,
+		named:    make(map[string]*Expr),
+		inline:   make(map[string]*Expr),
+		files:    make(map[string]*compiledExpr),
+	}
+}
+
+// Evaluate evaluates an inline expression or a named expression rule.
+func (x *ExprRuleEngine) Evaluate(ctx context.Context, ruleName string, data map[string]any) (bool, error) {
+	expr, err := x.getExpr(ruleName)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := expr.EvalBool(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate expression '%s': %w", expr, err)
+	}
+	return result, nil
+}
+
+// RegisterRule registers a named expression rule from its source text.
+func (x *ExprRuleEngine) RegisterRule(name string, rule any) error {
+	source, ok := rule.(string)
+	if !ok {
+		return fmt.Errorf("expression rule must be a string, got %T", rule)
+	}
+
+	expr, err := ParseExpr(source)
+	if err != nil {
+		return fmt.Errorf("invalid expression for rule '%s': %w", name, err)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.named[name] = expr
+	return nil
+}
+
+// Language returns the rule language
+func (x *ExprRuleEngine) Language() string {
+	return LanguageExpr
+}
+
+// Extensions returns the rule file extensions
+func (x *ExprRuleEngine) Extensions() []string {
+	return []string{".expr"}
+}
+
+// HasRule reports whether ruleName is an inline expression, a registered
+// expression or an .expr file.
+func (x *ExprRuleEngine) HasRule(ruleName string) bool {
+	if IsInlineExpression(ruleName) {
+		return true
+	}
+
+	x.mu.RLock()
+	_, ok := x.named[ruleName]
+	x.mu.RUnlock()
+	if ok {
+		return true
+	}
+
+	_, err := os.Stat(x.rulePath(ruleName))
+	return err == nil
+}
+
+// Check parses a rule and type-checks it against a schema.
+func (x *ExprRuleEngine) Check(ruleName string, schema ExprSchema) error {
+	expr, err := x.getExpr(ruleName)
+	if err != nil {
+		return err
+	}
+	if err := expr.Check(schema); err != nil {
+		return fmt.Errorf("expression '%s': %w", expr, err)
+	}
+	return nil
+}
+
+func (x *ExprRuleEngine) getExpr(ruleName string) (*Expr, error) {
+	if IsInlineExpression(ruleName) {
+		return x.getInline(ruleName)
+	}
+
+	x.mu.RLock()
+	expr, ok := x.named[ruleName]
+	x.mu.RUnlock()
+	if ok {
+		return expr, nil
+	}
+
+	return x.getFile(ruleName)
+}
+
+func (x *ExprRuleEngine) getInline(source string) (*Expr, error) {
+	x.mu.RLock()
+	expr, ok := x.inline[source]
+	x.mu.RUnlock()
+	if ok {
+		return expr, nil
+	}
+
+	expr, err := ParseExpr(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression '%s': %w", source, err)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.inline[source] = expr
+	return expr, nil
+}
+
+// getFile returns the parsed .expr file for a rule, re-parsing it when the
+// file has changed.
+func (x *ExprRuleEngine) getFile(ruleName string) (*Expr, error) {
+	path := x.rulePath(ruleName)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule file: %w", err)
+	}
+
+	x.mu.RLock()
+	cached, ok := x.files[ruleName]
+	ttl := x.cacheTTL
+	x.mu.RUnlock()
+	if ok && ruleCacheFresh(cached.modTime, cached.compiledAt, info, ttl) {
+		return cached.expr, nil
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule file: %w", err)
+	}
+
+	expr, err := ParseExpr(string(source))
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression in rule '%s': %w", ruleName, err)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.files[ruleName] = &compiledExpr{expr: expr, modTime: info.ModTime(), compiledAt: time.Now()}
+	return expr, nil
+}
+
+// SetCacheTTL sets how long parsed .expr files are kept before they are
+// parsed again.
+func (x *ExprRuleEngine) SetCacheTTL(ttl time.Duration) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.cacheTTL = ttl
+}
+
+func (x *ExprRuleEngine) rulePath(ruleName string) string {
+	return filepath.Join(x.rulesDir, fmt.Sprintf("%s.expr", ruleName))
+}
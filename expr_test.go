@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	data := map[string]any{
+		"age":      30,
+		"score":    7.5,
+		"name":     "Sam",
+		"tier":     "gold",
+		"premium":  true,
+		"blocked":  false,
+		"tags":     []any{"new", "vip"},
+		"address":  map[string]any{"country": "NO"},
+		"balances": []any{10, 20},
+	}
+
+	tests := []struct {
+		source  string
+		want    bool
+		wantErr string
+	}{
+		// Precedence: ! binds tighter than comparisons, which bind tighter
+		// than &&, which binds tighter than ||.
+		{source: "premium || blocked && false", want: true},
+		{source: "(premium || blocked) && false", want: false},
+		{source: "!blocked && premium", want: true},
+		{source: "!(premium && blocked)", want: true},
+		{source: "1 + 2 * 3 == 7", want: true},
+		{source: "(1 + 2) * 3 == 9", want: true},
+		{source: "10 - 4 - 3 == 3", want: true},
+		{source: "-2 * -3 == 6", want: true},
+		{source: "age % 7 == 2", want: true},
+		{source: "age + 1 > 30 && score * 2 == 15", want: true},
+
+		// Comparisons of numbers and of strings.
+		{source: "age >= 18", want: true},
+		{source: "age == 30.0", want: true},
+		{source: "score < 8", want: true},
+		{source: `name == "Sam"`, want: true},
+		{source: `name != 'sam'`, want: true},
+		{source: `tier < "silver"`, want: true},
+		{source: `"a" + "b" == "ab"`, want: true},
+		{source: `age == "30"`, want: false},
+		{source: `age > "18"`, wantErr: "cannot compare number with string"},
+		{source: `name < 3`, wantErr: "cannot compare string with number"},
+
+		// Missing fields.
+		{source: "missing == null", want: true},
+		{source: "missing != 1", want: true},
+		{source: "missing > 1", wantErr: "operator '>': cannot compare null with number"},
+		{source: "1 <= missing", wantErr: "operator '<=': cannot compare number with null"},
+		{source: "has(missing) && missing > 1", want: false},
+		{source: "address.missing.city == null", want: true},
+
+		// Collections and functions.
+		{source: `"vip" in tags`, want: true},
+		{source: `tier in ["gold", "platinum"]`, want: true},
+		{source: `"country" in address`, want: true},
+		{source: `address.country == "NO"`, want: true},
+		{source: `tags[1] == "vip" && tags[5] == null`, want: true},
+		{source: "balances[0] + balances[1] == 30", want: true},
+		{source: `name.startsWith("S") && size(tags) == 2`, want: true},
+		{source: `lower(name) == "sam"`, want: true},
+
+		// Runtime errors.
+		{source: "age", wantErr: "expression produced number, not bool"},
+		{source: "age / 0 > 1", wantErr: "division by zero"},
+		{source: "premium && age", wantErr: "operator '&&' expects bool operands"},
+		{source: "name - 1 == 0", wantErr: "operator '-' expects number operands"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expr, err := ParseExpr(tt.source)
+			if err != nil {
+				t.Fatalf("ParseExpr: %v", err)
+			}
+			got, err := expr.EvalBool(data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EvalBool: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("EvalBool = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		wantErr string
+	}{
+		{source: "age >= ", wantErr: "unexpected 'end of expression' at position 7"},
+		{source: "age >= 18)", wantErr: "unexpected ')' at position 9"},
+		{source: "(age >= 18", wantErr: "expected ')' but found 'end of expression' at position 10"},
+		{source: "1 < 2 < 3", wantErr: "unexpected '<' at position 6"},
+		{source: `name == "Sam`, wantErr: "unterminated string at position 8"},
+		{source: "age # 2", wantErr: "unexpected character '#' at position 4"},
+		{source: "address.18", wantErr: "expected field name but found '18' at position 8"},
+		{source: "tags[0 == 1", wantErr: "expected ']' but found 'end of expression' at position 11"},
+		{source: "1.2.3 > 0", wantErr: "invalid number '1.2.3' at position 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			_, err := ParseExpr(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExprCheck(t *testing.T) {
+	schema := ExprSchema{
+		"age":     TypeNumber,
+		"name":    TypeString,
+		"premium": TypeBool,
+		"tags":    TypeList,
+		"address": TypeMap,
+		"extra":   TypeAny,
+	}
+
+	tests := []struct {
+		source  string
+		wantErr string
+	}{
+		{source: "age >= 18 && premium"},
+		{source: `name.startsWith("S") || "vip" in tags`},
+		{source: `address.country == "NO"`},
+		{source: "extra > 1 && extra == 'x'"},
+		{source: "has(nickname)"},
+		{source: "age", wantErr: "expression produces number, not bool"},
+		{source: `age == "adult"`, wantErr: "cannot compare number with string"},
+		{source: "name >= 18", wantErr: "operator '>=' cannot compare string with number"},
+		{source: "premium < true", wantErr: "operator '<' cannot compare bool with bool"},
+		{source: "age && premium", wantErr: "operator '&&' expects bool operands, got number and bool"},
+		{source: "name * 2 > 1", wantErr: "operator '*' expects number operands, got string and number"},
+		{source: `age + "1" == 2`, wantErr: "operator '+' cannot combine number and string"},
+		{source: "age in 5", wantErr: "operator 'in' expects a list or map, got number"},
+		{source: "nickname == 'x'", wantErr: "unknown field 'nickname'"},
+		{source: "name.country == 'NO'", wantErr: "cannot select field 'country' from string"},
+		{source: "tags['x'] == 1", wantErr: "cannot index list with string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expr, err := ParseExpr(tt.source)
+			if err != nil {
+				t.Fatalf("ParseExpr: %v", err)
+			}
+			err = expr.Check(schema)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Check: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsInlineExpression(t *testing.T) {
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{rule: "is_over_18", want: false},
+		{rule: "credit-check", want: false},
+		{rule: "v1.approve", want: false},
+		{rule: "is_over_18.lua", want: false},
+		{rule: "age >= 18", want: true},
+		{rule: "!blocked", want: true},
+		{rule: "has(email)", want: true},
+		{rule: "-age", want: true},
+		{rule: "tags[0]", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := IsInlineExpression(tt.rule); got != tt.want {
+			t.Errorf("IsInlineExpression(%q) = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestExprRuleNames(t *testing.T) {
+	composite, _ := newLanguageTestEngine(t, map[string]string{
+		"credit-check.expr": "score > 5",
+		"v1.approve.lua":    "function check(data) return data.score > 5 end",
+	})
+
+	for _, name := range []string{"credit-check", "v1.approve", "v1.approve.lua"} {
+		passed, err := composite.Evaluate(context.Background(), name, map[string]any{"score": 7})
+		if err != nil || !passed {
+			t.Errorf("Evaluate(%q) = %v, %v, want the rule file to pass", name, passed, err)
+		}
+	}
+}
@@ -3,10 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -83,7 +87,8 @@ func (f *FileWorkflowStorage) ListWorkflows(ctx context.Context) ([]string, erro
 	return workflows, nil
 }
 
-// FileStateStorage implements StateStorage using the file system
+// FileStateStorage implements StateStorage using the file system. Each
+// instance is stored in <id>.json in the states directory.
 type FileStateStorage struct {
 	statesDir string
 }
@@ -95,17 +100,34 @@ func NewFileStateStorage(statesDir string) *FileStateStorage {
 	}
 }
 
-// SaveState saves a workflow state to a JSON file
+// validStateID reports whether an instance ID is safe to use as a file name.
+func validStateID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// SaveState saves a workflow state to a JSON file named after its ID
 func (f *FileStateStorage) SaveState(ctx context.Context, workflowName string, state *WorkflowState) error {
-	filename := fmt.Sprintf("%s_state.json", strings.ToLower(strings.ReplaceAll(workflowName, " ", "_")))
-	filePath := filepath.Join(f.statesDir, filename)
+	if !validStateID(state.ID) {
+		return fmt.Errorf("invalid state id '%s'", state.ID)
+	}
 
 	data, err := json.Marshal(state)
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if err := os.MkdirAll(f.statesDir, 0755); err != nil {
+		return fmt.Errorf("failed to create states directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(f.statesDir, state.ID+".json"), data, 0644); err != nil {
 		return fmt.Errorf("failed to write state file: %w", err)
 	}
 
@@ -114,10 +136,26 @@ func (f *FileStateStorage) SaveState(ctx context.Context, workflowName string, s
 
 // LoadState loads a workflow state from a JSON file
 func (f *FileStateStorage) LoadState(ctx context.Context, workflowName string, stateID string) (*WorkflowState, error) {
-	filename := fmt.Sprintf("%s_state.json", strings.ToLower(strings.ReplaceAll(workflowName, " ", "_")))
-	filePath := filepath.Join(f.statesDir, filename)
+	state, err := f.FindState(ctx, stateID)
+	if err != nil {
+		return nil, err
+	}
+	if state.Workflow != workflowName {
+		return nil, fmt.Errorf("%w: %s/%s", ErrStateNotFound, workflowName, stateID)
+	}
+	return state, nil
+}
 
-	data, err := os.ReadFile(filePath)
+// FindState loads a workflow state from a JSON file by ID alone
+func (f *FileStateStorage) FindState(ctx context.Context, stateID string) (*WorkflowState, error) {
+	if !validStateID(stateID) {
+		return nil, fmt.Errorf("%w: %s", ErrStateNotFound, stateID)
+	}
+
+	data, err := os.ReadFile(filepath.Join(f.statesDir, stateID+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrStateNotFound, stateID)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read state file: %w", err)
 	}
@@ -132,9 +170,14 @@ func (f *FileStateStorage) LoadState(ctx context.Context, workflowName string, s
 
 // FileRuleStorage implements RuleStorage using the file system
 type FileRuleStorage struct {
-	rulesDir string
+	rulesDir   string
+	versioning atomic.Bool
 }
 
+// ruleVersionsDir is the directory, inside the rules directory, where
+// previous versions of rules are kept when versioning is enabled.
+const ruleVersionsDir = ".versions"
+
 // NewFileRuleStorage creates a new file-based rule storage
 func NewFileRuleStorage(rulesDir string) *FileRuleStorage {
 	return &FileRuleStorage{
@@ -142,33 +185,149 @@ func NewFileRuleStorage(rulesDir string) *FileRuleStorage {
 	}
 }
 
-// SaveRule saves a rule to a Lua file
+// SetVersioning turns on or off keeping a copy of a rule's files in the
+// .versions directory before SaveRule replaces them. Copies are named
+// <rule>.<UTC timestamp><extension>.
+func (f *FileRuleStorage) SetVersioning(enabled bool) {
+	f.versioning.Store(enabled)
+}
+
+// ruleFileExtensions maps rule languages and formats to the extension of
+// their files. Languages are listed in the order of ruleLanguages, which is
+// the order LoadRule looks for them in.
+var ruleFileExtensions = []struct {
+	language  string
+	format    string
+	extension string
+}{
+	{LanguageExpr, "", ".expr"},
+	{LanguageDecisionTable, DecisionTableYAML, decisionTableExtensions[DecisionTableYAML]},
+	{LanguageDecisionTable, DecisionTableCSV, decisionTableExtensions[DecisionTableCSV]},
+	{LanguageLua, "", ".lua"},
+}
+
+// SaveRule saves a rule to a file whose extension matches its language.
+// Rules without a language are saved as Lua. Decision tables are checked
+// before saving and stored as YAML or CSV according to their format, which
+// is detected from the content when not given. Files of the rule in any other
+// language or format are removed.
 func (f *FileRuleStorage) SaveRule(ctx context.Context, rule Rule) error {
-	filename := fmt.Sprintf("%s.lua", rule.Name)
-	filePath := filepath.Join(f.rulesDir, filename)
+	language := rule.Language
+	if language == "" {
+		language = LanguageLua
+	}
+
+	format := rule.Format
+	if strings.EqualFold(language, LanguageDecisionTable) {
+		if format == "" {
+			format = DecisionTableFormat(rule.Content)
+		}
+		if err := validateDecisionTable(rule.Content, format); err != nil {
+			return err
+		}
+	}
+
+	for _, ext := range ruleFileExtensions {
+		if !strings.EqualFold(ext.language, language) || !strings.EqualFold(ext.format, format) {
+			continue
+		}
+
+		filename := fmt.Sprintf("%s%s", rule.Name, ext.extension)
+		filePath := filepath.Join(f.rulesDir, filename)
+
+		if f.versioning.Load() {
+			if err := f.backupRule(rule.Name, time.Now()); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(filePath, []byte(rule.Content), 0644); err != nil {
+			return fmt.Errorf("failed to write rule file: %w", err)
+		}
+		return f.removeOtherFiles(rule.Name, ext.extension)
+	}
 
-	if err := os.WriteFile(filePath, []byte(rule.Content), 0644); err != nil {
-		return fmt.Errorf("failed to write rule file: %w", err)
+	if format != "" {
+		return fmt.Errorf("unsupported format '%s' for %s rules", format, language)
 	}
+	return fmt.Errorf("unsupported rule language '%s'", rule.Language)
+}
+
+// backupRule copies every existing file of a rule into the versions
+// directory.
+func (f *FileRuleStorage) backupRule(name string, now time.Time) error {
+	for _, ext := range ruleFileExtensions {
+		content, err := os.ReadFile(filepath.Join(f.rulesDir, name+ext.extension))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to back up rule file: %w", err)
+		}
+
+		dir := filepath.Join(f.rulesDir, ruleVersionsDir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create rule versions directory: %w", err)
+		}
+		backup := fmt.Sprintf("%s.%s%s", name, now.UTC().Format("20060102T150405.000Z"), ext.extension)
+		if err := os.WriteFile(filepath.Join(dir, backup), content, 0644); err != nil {
+			return fmt.Errorf("failed to back up rule file: %w", err)
+		}
+	}
+	return nil
+}
 
+// removeOtherFiles deletes files of the same rule saved in another language
+// or format, so that a rule rewritten from Lua to Expr, or a table converted
+// from CSV to YAML, isn't left behind in both.
+func (f *FileRuleStorage) removeOtherFiles(name, keep string) error {
+	for _, ext := range ruleFileExtensions {
+		if ext.extension == keep {
+			continue
+		}
+		err := os.Remove(filepath.Join(f.rulesDir, name+ext.extension))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove old rule file: %w", err)
+		}
+	}
 	return nil
 }
 
-// LoadRule loads a rule from a Lua file
+func validateDecisionTable(content, format string) error {
+	var err error
+	switch strings.ToLower(format) {
+	case DecisionTableYAML:
+		_, err = ParseDecisionTableYAML(content)
+	case DecisionTableCSV:
+		_, err = ParseDecisionTableCSV(content)
+	default:
+		return fmt.Errorf("unsupported format '%s' for %s rules", format, LanguageDecisionTable)
+	}
+	return err
+}
+
+// LoadRule loads a rule from the first file found for any language
 func (f *FileRuleStorage) LoadRule(ctx context.Context, name string) (*Rule, error) {
-	filename := fmt.Sprintf("%s.lua", name)
-	filePath := filepath.Join(f.rulesDir, filename)
+	for _, ext := range ruleFileExtensions {
+		filename := fmt.Sprintf("%s%s", name, ext.extension)
+		filePath := filepath.Join(f.rulesDir, filename)
 
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read rule file: %w", err)
+		content, err := os.ReadFile(filePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rule file: %w", err)
+		}
+
+		return &Rule{
+			Name:     name,
+			Language: ext.language,
+			Format:   ext.format,
+			Content:  string(content),
+		}, nil
 	}
 
-	return &Rule{
-		Name:     name,
-		Language: "Lua",
-		Content:  string(content),
-	}, nil
+	return nil, fmt.Errorf("failed to read rule file: %w", fs.ErrNotExist)
 }
 
 // ListRules lists all rule files in the directory
@@ -180,15 +339,26 @@ func (f *FileRuleStorage) ListRules(ctx context.Context) ([]Rule, error) {
 
 	var rules []Rule
 	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".lua") {
-			name := strings.TrimSuffix(file.Name(), ".lua")
-			rule, err := f.LoadRule(ctx, name)
+		if file.IsDir() {
+			continue
+		}
+		for _, ext := range ruleFileExtensions {
+			if !strings.HasSuffix(file.Name(), ext.extension) {
+				continue
+			}
+			name := strings.TrimSuffix(file.Name(), ext.extension)
+			content, err := os.ReadFile(filepath.Join(f.rulesDir, file.Name()))
 			if err != nil {
 				continue
 			}
-			rules = append(rules, *rule)
+			rules = append(rules, Rule{
+				Name:     name,
+				Language: ext.language,
+				Format:   ext.format,
+				Content:  string(content),
+			})
 		}
 	}
 
 	return rules, nil
-}
\ No newline at end of file
+}
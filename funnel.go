@@ -0,0 +1,275 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Each daily funnel keeps up to funnelMaxPaths distinct paths, counting
+// the rest as other paths, and up to funnelEdgeSamples durations per edge
+// for estimating the median time between steps.
+const (
+	funnelMaxPaths    = 200
+	funnelEdgeSamples = 100
+	funnelTopPaths    = 10
+)
+
+// FunnelRollup aggregates the steps and transitions taken by the instances
+// of a workflow that finished on one day (UTC).
+type FunnelRollup struct {
+	Day        time.Time              `json:"day"`
+	Workflow   string                 `json:"workflow"`
+	Instances  int                    `json:"instances"`
+	Steps      map[string]int         `json:"steps"`
+	Edges      map[string]*FunnelEdge `json:"edges"`
+	Paths      map[string]*FunnelPath `json:"paths"`
+	OtherPaths int                    `json:"other_paths,omitempty"`
+}
+
+type funnelKey struct {
+	day      int64
+	workflow string
+}
+
+// FunnelEdge counts the transitions from one step to another. Samples
+// holds durations, in milliseconds, from entering the from step to
+// entering the to step.
+type FunnelEdge struct {
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Count    int       `json:"count"`
+	Rate     float64   `json:"rate"`
+	MedianMS float64   `json:"median_ms"`
+	Samples  []float64 `json:"samples,omitempty"`
+}
+
+// FunnelPath counts the instances that took the same path of steps.
+type FunnelPath struct {
+	Steps []string `json:"steps"`
+	Count int      `json:"count"`
+	Rate  float64  `json:"rate"`
+}
+
+// FunnelStep counts the times instances entered a step and left it by a
+// transition. DropOffRate is the fraction of entries that didn't leave,
+// because the instance ended or failed there.
+type FunnelStep struct {
+	Step        string  `json:"step"`
+	Visits      int     `json:"visits"`
+	Exits       int     `json:"exits"`
+	DropOffRate float64 `json:"drop_off_rate"`
+}
+
+// FunnelReport describes how a workflow's instances moved through its
+// steps between From and To: the steps are the nodes and the edges the
+// links of a Sankey diagram. Edge rates are fractions of the from step's
+// visits; path rates are fractions of the instances.
+type FunnelReport struct {
+	Workflow   string       `json:"workflow"`
+	From       time.Time    `json:"from"`
+	To         time.Time    `json:"to"`
+	Instances  int          `json:"instances"`
+	Steps      []FunnelStep `json:"steps"`
+	Edges      []FunnelEdge `json:"edges"`
+	Paths      []FunnelPath `json:"paths"`
+	OtherPaths int          `json:"other_paths"`
+}
+
+func (f *FunnelRollup) key() funnelKey {
+	return funnelKey{day: f.Day.Unix(), workflow: f.Workflow}
+}
+
+// recordFunnel adds a finished instance's path and transitions to the
+// funnel for the day it finished. The caller must hold the lock.
+func (a *Analytics) recordFunnel(workflowName string, finished time.Time, state *WorkflowState) {
+	if len(state.Path) == 0 {
+		return
+	}
+
+	day := periodStart(PeriodDay, finished)
+	key := funnelKey{day: day.Unix(), workflow: workflowName}
+	funnel, ok := a.funnels[key]
+	if !ok {
+		funnel = &FunnelRollup{
+			Day:      day,
+			Workflow: workflowName,
+			Steps:    make(map[string]int),
+			Edges:    make(map[string]*FunnelEdge),
+			Paths:    make(map[string]*FunnelPath),
+		}
+		a.funnels[key] = funnel
+	}
+
+	funnel.Instances++
+	for _, step := range state.Path {
+		funnel.Steps[step]++
+	}
+
+	pathKey := strings.Join(state.Path, "\x00")
+	if path, ok := funnel.Paths[pathKey]; ok {
+		path.Count++
+	} else if len(funnel.Paths) < funnelMaxPaths {
+		funnel.Paths[pathKey] = &FunnelPath{Steps: append([]string(nil), state.Path...), Count: 1}
+	} else {
+		funnel.OtherPaths++
+	}
+
+	// A step is entered when the transition into it, including its
+	// actions, finishes; the start step when the instance starts.
+	entered := state.StartedAt
+	for _, entry := range state.Trace {
+		left := entry.StartedAt.Add(time.Duration(entry.DurationMS * float64(time.Millisecond)))
+		if entry.Error != "" {
+			break
+		}
+
+		edgeKey := entry.FromStep + "\x00" + entry.ToStep
+		edge, ok := funnel.Edges[edgeKey]
+		if !ok {
+			edge = &FunnelEdge{From: entry.FromStep, To: entry.ToStep}
+			funnel.Edges[edgeKey] = edge
+		}
+		edge.Count++
+		if !entered.IsZero() {
+			edge.sample(float64(left.Sub(entered)) / float64(time.Millisecond))
+		}
+		entered = left
+	}
+}
+
+// sample keeps a uniform random sample of the edge's durations.
+func (e *FunnelEdge) sample(ms float64) {
+	if len(e.Samples) < funnelEdgeSamples {
+		e.Samples = append(e.Samples, ms)
+		return
+	}
+	if i := rand.IntN(e.Count); i < funnelEdgeSamples {
+		e.Samples[i] = ms
+	}
+}
+
+// median returns the median of values, sorting them.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// Funnel reports how the instances of a workflow that finished between
+// from and to moved through its steps. The range is widened to whole days.
+func (a *Analytics) Funnel(from, to time.Time, workflow string) *FunnelReport {
+	from = periodStart(PeriodDay, from)
+	if start := periodStart(PeriodDay, to); start.Before(to) {
+		to = start.Add(24 * time.Hour)
+	}
+	report := &FunnelReport{Workflow: workflow, From: from, To: to.UTC()}
+
+	steps := make(map[string]*FunnelStep)
+	edges := make(map[string]*FunnelEdge)
+	paths := make(map[string]*FunnelPath)
+
+	a.mu.Lock()
+	for _, funnel := range a.funnels {
+		if funnel.Workflow != workflow || funnel.Day.Before(from) || !funnel.Day.Before(to) {
+			continue
+		}
+
+		report.Instances += funnel.Instances
+		report.OtherPaths += funnel.OtherPaths
+		for name, visits := range funnel.Steps {
+			step, ok := steps[name]
+			if !ok {
+				step = &FunnelStep{Step: name}
+				steps[name] = step
+			}
+			step.Visits += visits
+		}
+		for key, e := range funnel.Edges {
+			edge, ok := edges[key]
+			if !ok {
+				edge = &FunnelEdge{From: e.From, To: e.To}
+				edges[key] = edge
+			}
+			edge.Count += e.Count
+			edge.Samples = append(edge.Samples, e.Samples...)
+		}
+		for key, p := range funnel.Paths {
+			path, ok := paths[key]
+			if !ok {
+				path = &FunnelPath{Steps: p.Steps}
+				paths[key] = path
+			}
+			path.Count += p.Count
+		}
+	}
+	a.mu.Unlock()
+
+	for _, edge := range edges {
+		if step, ok := steps[edge.From]; ok {
+			step.Exits += edge.Count
+		}
+	}
+
+	report.Steps = make([]FunnelStep, 0, len(steps))
+	for _, step := range steps {
+		if step.Visits > 0 {
+			step.DropOffRate = float64(step.Visits-step.Exits) / float64(step.Visits)
+		}
+		report.Steps = append(report.Steps, *step)
+	}
+	sort.Slice(report.Steps, func(i, j int) bool {
+		si, sj := report.Steps[i], report.Steps[j]
+		if si.Visits != sj.Visits {
+			return si.Visits > sj.Visits
+		}
+		return si.Step < sj.Step
+	})
+
+	report.Edges = make([]FunnelEdge, 0, len(edges))
+	for _, edge := range edges {
+		if step, ok := steps[edge.From]; ok && step.Visits > 0 {
+			edge.Rate = float64(edge.Count) / float64(step.Visits)
+		}
+		edge.MedianMS = median(edge.Samples)
+		edge.Samples = nil
+		report.Edges = append(report.Edges, *edge)
+	}
+	sort.Slice(report.Edges, func(i, j int) bool {
+		ei, ej := report.Edges[i], report.Edges[j]
+		if ei.Count != ej.Count {
+			return ei.Count > ej.Count
+		}
+		if ei.From != ej.From {
+			return ei.From < ej.From
+		}
+		return ei.To < ej.To
+	})
+
+	report.Paths = make([]FunnelPath, 0, len(paths))
+	for _, path := range paths {
+		if report.Instances > 0 {
+			path.Rate = float64(path.Count) / float64(report.Instances)
+		}
+		report.Paths = append(report.Paths, *path)
+	}
+	sort.Slice(report.Paths, func(i, j int) bool {
+		pi, pj := report.Paths[i], report.Paths[j]
+		if pi.Count != pj.Count {
+			return pi.Count > pj.Count
+		}
+		return strings.Join(pi.Steps, "\x00") < strings.Join(pj.Steps, "\x00")
+	})
+	if len(report.Paths) > funnelTopPaths {
+		report.Paths = report.Paths[:funnelTopPaths]
+	}
+
+	return report
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Input schema types.
+const (
+	SchemaObject  = "object"
+	SchemaString  = "string"
+	SchemaNumber  = "number"
+	SchemaInteger = "integer"
+	SchemaBoolean = "boolean"
+	SchemaArray   = "array"
+	SchemaNull    = "null"
+)
+
+// InputSchema describes the data a workflow instance must be started with.
+// It follows a small subset of JSON Schema:
+//
+//	input_schema:
+//	  type: object
+//	  required: [name, age]
+//	  properties:
+//	    name: {type: string}
+//	    age: {type: integer, minimum: 0, maximum: 150}
+//	    customer_type: {type: string, enum: [standard, premium]}
+type InputSchema struct {
+	Type       string                  `json:"type,omitempty" yaml:"type,omitempty"`
+	Required   []string                `json:"required,omitempty" yaml:"required,omitempty"`
+	Properties map[string]*InputSchema `json:"properties,omitempty" yaml:"properties,omitempty"`
+	Items      *InputSchema            `json:"items,omitempty" yaml:"items,omitempty"`
+	Enum       []any                   `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Minimum    *float64                `json:"minimum,omitempty" yaml:"minimum,omitempty"`
+	Maximum    *float64                `json:"maximum,omitempty" yaml:"maximum,omitempty"`
+}
+
+// FieldError describes one field that failed validation. Field is a path
+// such as "address.country" or "items[0]"; it is empty for the data as a
+// whole.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when workflow data fails validation.
+type ValidationError struct {
+	Workflow string       `json:"workflow"`
+	Fields   []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		if f.Field == "" {
+			parts[i] = f.Message
+		} else {
+			parts[i] = fmt.Sprintf("%s: %s", f.Field, f.Message)
+		}
+	}
+	return fmt.Sprintf("invalid data for workflow '%s': %s", e.Workflow, strings.Join(parts, "; "))
+}
+
+// Check verifies that the schema itself is well formed.
+func (s *InputSchema) Check() error {
+	return s.check("")
+}
+
+func (s *InputSchema) check(path string) error {
+	if s == nil {
+		return fmt.Errorf("%s: empty schema", schemaPath(path))
+	}
+
+	switch s.Type {
+	case "", SchemaObject, SchemaString, SchemaNumber, SchemaInteger, SchemaBoolean, SchemaArray, SchemaNull:
+	default:
+		return fmt.Errorf("%s: unknown type '%s'", schemaPath(path), s.Type)
+	}
+	if s.Minimum != nil && s.Maximum != nil && *s.Minimum > *s.Maximum {
+		return fmt.Errorf("%s: minimum is greater than maximum", schemaPath(path))
+	}
+
+	for _, name := range sortedKeys(s.Properties) {
+		if err := s.Properties[name].check(joinFieldPath(path, name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(path + "[]")
+	}
+	return nil
+}
+
+// Validate checks data against the schema and returns every field that
+// does not conform, or nil if the data is valid.
+func (s *InputSchema) Validate(data map[string]any) []FieldError {
+	var errs []FieldError
+	s.validate("", data, &errs)
+	return errs
+}
+
+func (s *InputSchema) validate(path string, value any, errs *[]FieldError) {
+	value = normalizeValue(value)
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type != "" && !schemaTypeMatches(s.Type, value) {
+		fail("must be of type %s, got %s", s.Type, schemaTypeOf(value))
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if valuesEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", formatEnum(s.Enum))
+		}
+	}
+
+	if n, ok := value.(float64); ok {
+		if s.Minimum != nil && n < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, FieldError{Field: joinFieldPath(path, name), Message: "is required"})
+			}
+		}
+		for _, name := range sortedKeys(s.Properties) {
+			if field, ok := v[name]; ok {
+				s.Properties[name].validate(joinFieldPath(path, name), field, errs)
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	}
+}
+
+func schemaTypeMatches(schemaType string, value any) bool {
+	if schemaType == SchemaInteger {
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	}
+	actual := schemaTypeOf(value)
+	return actual == schemaType || (schemaType == SchemaNumber && actual == SchemaInteger)
+}
+
+// schemaTypeOf returns the input schema type of a normalized value.
+func schemaTypeOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return SchemaNull
+	case bool:
+		return SchemaBoolean
+	case float64:
+		if v == math.Trunc(v) {
+			return SchemaInteger
+		}
+		return SchemaNumber
+	case string:
+		return SchemaString
+	case []any:
+		return SchemaArray
+	case map[string]any:
+		return SchemaObject
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func formatEnum(values []any) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%v", v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "input_schema"
+	}
+	return fmt.Sprintf("input_schema field '%s'", path)
+}
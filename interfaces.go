@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
 // RuleEngine defines the interface for rule evaluation
@@ -10,6 +12,46 @@ type RuleEngine interface {
 	RegisterRule(name string, rule any) error
 }
 
+// RuleLookup is implemented by rule engines that can tell whether a rule
+// exists, which lets workflows be validated when they are loaded.
+type RuleLookup interface {
+	HasRule(name string) bool
+}
+
+// LanguageRuleEngine is a RuleEngine for a single rule language. It reports
+// which rules it has so that a CompositeRuleEngine can dispatch to it.
+type LanguageRuleEngine interface {
+	RuleEngine
+	RuleLookup
+	Language() string
+	Extensions() []string
+}
+
+// RuleChecker is implemented by rule engines that can type-check a rule
+// against a workflow's data schema when the workflow is loaded.
+type RuleChecker interface {
+	Check(ruleName string, schema ExprSchema) error
+}
+
+// MutatingRuleEngine is implemented by rule engines whose rules may modify
+// the data they are evaluated against. The returned map is the full data set
+// as left by the rule.
+type MutatingRuleEngine interface {
+	EvaluateMutating(ctx context.Context, ruleName string, data map[string]any) (bool, map[string]any, error)
+}
+
+// RuleCache is implemented by rule engines that cache compiled rule files.
+// Rules compiled longer ago than the TTL are recompiled even if their file
+// has not changed; a zero TTL keeps them until the file changes.
+type RuleCache interface {
+	SetCacheTTL(ttl time.Duration)
+}
+
+// ActionRunner defines the interface for executing step actions
+type ActionRunner interface {
+	RunAction(ctx context.Context, actionName string, state *WorkflowState) error
+}
+
 // WorkflowStorage defines the interface for workflow persistence
 type WorkflowStorage interface {
 	SaveWorkflow(ctx context.Context, workflow Workflow) error
@@ -17,10 +59,16 @@ type WorkflowStorage interface {
 	ListWorkflows(ctx context.Context) ([]string, error)
 }
 
-// StateStorage defines the interface for workflow state persistence
+// ErrStateNotFound is returned when no state is stored for an instance.
+var ErrStateNotFound = errors.New("state not found")
+
+// StateStorage defines the interface for workflow state persistence. States
+// are stored by instance ID.
 type StateStorage interface {
 	SaveState(ctx context.Context, workflowName string, state *WorkflowState) error
 	LoadState(ctx context.Context, workflowName string, stateID string) (*WorkflowState, error)
+	// FindState loads an instance's state by ID alone, whatever its workflow.
+	FindState(ctx context.Context, stateID string) (*WorkflowState, error)
 }
 
 // RuleStorage defines the interface for rule persistence
@@ -30,9 +78,50 @@ type RuleStorage interface {
 	ListRules(ctx context.Context) ([]Rule, error)
 }
 
-// EventHandler defines the interface for workflow events
+// EventHandler defines the interface for workflow events. The engine calls
+// it through an EventListener adapter; handlers wanting more kinds of events
+// should implement EventListener instead.
 type EventHandler interface {
 	OnWorkflowStart(ctx context.Context, workflowName string, state *WorkflowState) error
 	OnWorkflowEnd(ctx context.Context, workflowName string, state *WorkflowState) error
 	OnStepTransition(ctx context.Context, workflowName string, fromStep, toStep string, state *WorkflowState) error
-}
\ No newline at end of file
+}
+
+// FailureHandler is implemented by event handlers that want to know when a
+// workflow instance fails, including when its input is rejected before it
+// starts. Errors it returns are logged and do not change the outcome.
+type FailureHandler interface {
+	OnWorkflowFailed(ctx context.Context, workflowName string, state *WorkflowState, err error) error
+}
+
+// EventListener receives every workflow event as a typed WorkflowEvent.
+// Kinds of events may be added over time; listeners should ignore kinds
+// they don't know. An error returned for an event other than the end of an
+// instance fails the instance, unless the listener is an AsyncHandler.
+type EventListener interface {
+	OnEvent(ctx context.Context, event *WorkflowEvent) error
+}
+
+// EventFilter is implemented by event listeners that only want some kinds
+// of events; the others are neither queued nor delivered to them.
+type EventFilter interface {
+	WantsEvent(kind EventKind) bool
+}
+
+// AsyncHandler is implemented by event handlers and listeners, such as
+// notifications, that must not hold up or fail workflows. When the engine
+// has an outbox, their events are queued there and delivered in the
+// background, and an error returned by the handler makes the outbox retry
+// the event. Without an outbox they are called as the workflow runs, but
+// their errors are only logged. HandlerName identifies the handler's events
+// in the outbox across restarts.
+type AsyncHandler interface {
+	HandlerName() string
+}
+
+// SpanExporter sends finished spans to a tracing backend. The tracer calls
+// ExportSpans from a single goroutine, with batches of spans.
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []*Span) error
+	Shutdown(ctx context.Context) error
+}
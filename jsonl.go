@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxJSONLLine is the longest request line accepted, in bytes.
+const maxJSONLLine = 10 << 20
+
+// ExecutionRequest asks for one workflow instance to be run. Requests are
+// read one per line from newline-delimited JSON:
+//
+//	{"id": "r1", "workflow": "CustomerOnboarding", "data": {"name": "Alice", "age": 25}}
+type ExecutionRequest struct {
+	ID       string         `json:"id,omitempty"`
+	Workflow string         `json:"workflow"`
+	Data     map[string]any `json:"data"`
+}
+
+// ExecutionResult is the outcome of one execution request.
+type ExecutionResult struct {
+	Line      int            `json:"line"`
+	ID        string         `json:"id,omitempty"`
+	Workflow  string         `json:"workflow"`
+	Status    string         `json:"status"`
+	FinalStep string         `json:"final_step,omitempty"`
+	Path      []string       `json:"path,omitempty"`
+	Data      map[string]any `json:"data,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// JSONLSummary counts the requests processed from a JSONL stream.
+type JSONLSummary struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// RunJSONL executes each request read from r in order and writes one
+// ExecutionResult per request to w as it completes. Lines that are blank are
+// skipped; lines that cannot be parsed produce a failed result. The error
+// returned is only for failures reading or writing the streams.
+func (e *WorkflowEngine) RunJSONL(ctx context.Context, r io.Reader, w io.Writer) (JSONLSummary, error) {
+	var summary JSONLSummary
+	encoder := json.NewEncoder(w)
+
+	err := scanRequests(ctx, r, func(line int, req ExecutionRequest, parseErr error) error {
+		result := ExecutionResult{Line: line, ID: req.ID, Workflow: req.Workflow}
+		if parseErr != nil {
+			result.Status = StatusFailed
+			result.Error = parseErr.Error()
+		} else {
+			state := &WorkflowState{Data: req.Data}
+			err := e.RunWorkflow(ctx, req.Workflow, state)
+			result.Status = state.Status
+			result.FinalStep = state.CurrentStep
+			result.Path = state.Path
+			result.Data = state.Data
+			result.Error = state.Error
+			if err != nil {
+				result.Status = StatusFailed
+				if result.Error == "" {
+					result.Error = err.Error()
+				}
+			}
+		}
+
+		summary.Total++
+		if result.Status == StatusCompleted {
+			summary.Completed++
+		} else {
+			summary.Failed++
+		}
+		return encoder.Encode(result)
+	})
+	return summary, err
+}
+
+// ReplayOutcome is how one version of a workflow handled a request.
+type ReplayOutcome struct {
+	Status    string   `json:"status"`
+	FinalStep string   `json:"final_step,omitempty"`
+	Path      []string `json:"path,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// ReplayResult compares the outcome of a request under the registered
+// workflow with its outcome under a candidate version.
+type ReplayResult struct {
+	Line      int           `json:"line"`
+	ID        string        `json:"id,omitempty"`
+	Workflow  string        `json:"workflow"`
+	Baseline  ReplayOutcome `json:"baseline"`
+	Candidate ReplayOutcome `json:"candidate"`
+	Changed   bool          `json:"changed"`
+}
+
+// ReplayOptions control a replay.
+type ReplayOptions struct {
+	// ChangedOnly writes results only for requests whose outcome changed.
+	ChangedOnly bool
+	// RunActions runs the steps' on_enter and on_exit actions, which are
+	// skipped by default as they may have side effects, such as notifying
+	// other systems, that must not be repeated for captured requests.
+	RunActions bool
+}
+
+// ReplaySummary counts the requests replayed and how many had a different
+// outcome under the candidate. Requests for other workflows, or that cannot
+// be parsed, are skipped.
+type ReplaySummary struct {
+	Total   int `json:"total"`
+	Changed int `json:"changed"`
+	Skipped int `json:"skipped"`
+}
+
+// Replay runs each request for the candidate's workflow read from r twice,
+// once with the registered version of the workflow and once with the
+// candidate, and writes a ReplayResult per request to w. Event handlers are
+// not notified of replayed runs, and step actions only run if
+// opts.RunActions is set.
+func (e *WorkflowEngine) Replay(ctx context.Context, r io.Reader, candidate Workflow, w io.Writer, opts ReplayOptions) (ReplaySummary, error) {
+	var summary ReplaySummary
+
+	baseline, ok := e.Workflow(candidate.Name)
+	if !ok {
+		return summary, fmt.Errorf("workflow '%s' not found", candidate.Name)
+	}
+	if err := e.validateWorkflow(&candidate); err != nil {
+		return summary, fmt.Errorf("invalid candidate workflow: %w", err)
+	}
+	if !opts.RunActions {
+		// Without step declarations no actions run.
+		baseline.Steps, candidate.Steps = nil, nil
+	}
+
+	encoder := json.NewEncoder(w)
+	err := scanRequests(ctx, r, func(line int, req ExecutionRequest, parseErr error) error {
+		if parseErr != nil || req.Workflow != candidate.Name {
+			summary.Skipped++
+			return nil
+		}
+
+		result := ReplayResult{
+			Line:      line,
+			ID:        req.ID,
+			Workflow:  req.Workflow,
+			Baseline:  e.replayOutcome(ctx, baseline, req.Data),
+			Candidate: e.replayOutcome(ctx, candidate, req.Data),
+		}
+		result.Changed = !replayOutcomesEqual(result.Baseline, result.Candidate)
+
+		summary.Total++
+		if result.Changed {
+			summary.Changed++
+		} else if opts.ChangedOnly {
+			return nil
+		}
+		return encoder.Encode(result)
+	})
+	return summary, err
+}
+
+func (e *WorkflowEngine) replayOutcome(ctx context.Context, wf Workflow, data map[string]any) ReplayOutcome {
+	state := &WorkflowState{Data: CopyData(data)}
+	err := e.runWorkflow(ctx, wf, state, nil)
+
+	outcome := ReplayOutcome{
+		Status:    state.Status,
+		FinalStep: state.CurrentStep,
+		Path:      state.Path,
+		Error:     state.Error,
+	}
+	if err != nil {
+		outcome.Status = StatusFailed
+		if outcome.Error == "" {
+			outcome.Error = err.Error()
+		}
+	}
+	return outcome
+}
+
+func replayOutcomesEqual(a, b ReplayOutcome) bool {
+	return a.Status == b.Status &&
+		a.FinalStep == b.FinalStep &&
+		a.Error == b.Error &&
+		strings.Join(a.Path, "\x00") == strings.Join(b.Path, "\x00")
+}
+
+// scanRequests reads execution requests line by line and calls fn for each
+// non-blank line with its 1-based line number. It stops when fn returns an
+// error or the context is cancelled.
+func scanRequests(ctx context.Context, r io.Reader, fn func(line int, req ExecutionRequest, parseErr error) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxJSONLLine)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var req ExecutionRequest
+		var parseErr error
+		if err := json.Unmarshal([]byte(text), &req); err != nil {
+			parseErr = fmt.Errorf("invalid request: %w", err)
+		} else if req.Workflow == "" {
+			parseErr = fmt.Errorf("invalid request: workflow is required")
+		}
+
+		if err := fn(line, req, parseErr); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read requests: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const adultWorkflow = `
+name: Adult
+start_step: start
+steps:
+  - name: adult
+    on_enter: [notify]
+transitions:
+  - from: start
+    to: adult
+    rule: age >= 18
+    fallback_to: minor
+`
+
+// newReplayEngine creates an engine with the Adult workflow, whose notify
+// action counts its calls.
+func newReplayEngine(t *testing.T) (*WorkflowEngine, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	registry := NewRegistry()
+	registry.RegisterAction("notify", func(ctx context.Context, state *WorkflowState) error {
+		calls.Add(1)
+		return nil
+	})
+	engine, err := newTestEngine(t, EngineOptions{Registry: registry}, map[string]string{"adult.yml": adultWorkflow}, nil)
+	if err != nil {
+		t.Fatalf("NewWorkflowEngine: %v", err)
+	}
+	return engine, &calls
+}
+
+func TestReplaySkipsActions(t *testing.T) {
+	engine, calls := newReplayEngine(t)
+	candidate, _ := engine.Workflow("Adult")
+	requests := `{"workflow": "Adult", "data": {"age": 30}}` + "\n"
+
+	var out bytes.Buffer
+	if _, err := engine.Replay(context.Background(), strings.NewReader(requests), candidate, &out, ReplayOptions{}); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("notify ran %d times during replay, want 0", n)
+	}
+	if !strings.Contains(out.String(), `"final_step":"adult"`) {
+		t.Errorf("replay output = %s", out.String())
+	}
+
+	if _, err := engine.Replay(context.Background(), strings.NewReader(requests), candidate, &out, ReplayOptions{RunActions: true}); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("notify ran %d times with RunActions, want 2", n)
+	}
+	if wf, _ := engine.Workflow("Adult"); len(wf.Steps) != 1 {
+		t.Errorf("replay changed the registered workflow's steps: %+v", wf.Steps)
+	}
+}
+
+func decodeLines[T any](t *testing.T, data []byte) []T {
+	t.Helper()
+	var values []T
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var v T
+		if err := decoder.Decode(&v); err != nil {
+			t.Fatalf("invalid output: %v", err)
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestRunJSONL(t *testing.T) {
+	engine, _ := newReplayEngine(t)
+	requests := strings.Join([]string{
+		`{"id": "a", "workflow": "Adult", "data": {"age": 30}}`,
+		``,
+		`{"id": "b", "workflow": "Adult", "data": {"age": 12}}`,
+		`not json`,
+		`{"id": "c", "data": {"age": 30}}`,
+		`{"id": "d", "workflow": "Missing", "data": {}}`,
+		`{"id": "e", "workflow": "Adult", "data": {"age": "old"}}`,
+	}, "\n")
+
+	var out bytes.Buffer
+	summary, err := engine.RunJSONL(context.Background(), strings.NewReader(requests), &out)
+	if err != nil {
+		t.Fatalf("RunJSONL: %v", err)
+	}
+	if summary != (JSONLSummary{Total: 6, Completed: 2, Failed: 4}) {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	results := decodeLines[ExecutionResult](t, out.Bytes())
+	want := []struct {
+		line      int
+		id        string
+		status    string
+		finalStep string
+		err       string
+	}{
+		{1, "a", StatusCompleted, "adult", ""},
+		{3, "b", StatusCompleted, "minor", ""},
+		{4, "", StatusFailed, "", "invalid request"},
+		{5, "c", StatusFailed, "", "workflow is required"},
+		{6, "d", StatusFailed, "", "workflow 'Missing' not found"},
+		{7, "e", StatusFailed, "start", "cannot compare"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %s", len(results), len(want), out.String())
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Line != w.line || r.ID != w.id || r.Status != w.status || r.FinalStep != w.finalStep || !strings.Contains(r.Error, w.err) {
+			t.Errorf("result %d = %+v, want %+v", i, r, w)
+		}
+	}
+	if path := strings.Join(results[0].Path, ","); path != "start,adult" {
+		t.Errorf("path = %s, want start,adult", path)
+	}
+}
+
+func TestReplayOutcomes(t *testing.T) {
+	engine, _ := newReplayEngine(t)
+	candidate, _ := engine.Workflow("Adult")
+	candidate.Transitions = []Transition{{FromStep: "start", ToStep: "adult", Rule: RuleSpec{Name: "age >= 21"}, FallbackStep: "minor"}}
+	requests := strings.Join([]string{
+		`{"id": "a", "workflow": "Adult", "data": {"age": 30}}`,
+		`{"id": "b", "workflow": "Adult", "data": {"age": 19}}`,
+		`{"id": "c", "workflow": "Other", "data": {"age": 19}}`,
+		`not json`,
+		`{"id": "d", "workflow": "Adult", "data": {"age": 12}}`,
+	}, "\n")
+
+	var out bytes.Buffer
+	summary, err := engine.Replay(context.Background(), strings.NewReader(requests), candidate, &out, ReplayOptions{})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if summary != (ReplaySummary{Total: 3, Changed: 1, Skipped: 2}) {
+		t.Errorf("summary = %+v", summary)
+	}
+	results := decodeLines[ReplayResult](t, out.Bytes())
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3: %s", len(results), out.String())
+	}
+	changed := results[1]
+	if changed.ID != "b" || !changed.Changed || changed.Baseline.FinalStep != "adult" || changed.Candidate.FinalStep != "minor" {
+		t.Errorf("changed result = %+v", changed)
+	}
+	for _, r := range []ReplayResult{results[0], results[2]} {
+		if r.Changed || r.Baseline.FinalStep != r.Candidate.FinalStep {
+			t.Errorf("unchanged result = %+v", r)
+		}
+	}
+
+	out.Reset()
+	if _, err := engine.Replay(context.Background(), strings.NewReader(requests), candidate, &out, ReplayOptions{ChangedOnly: true}); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if results := decodeLines[ReplayResult](t, out.Bytes()); len(results) != 1 || results[0].ID != "b" {
+		t.Errorf("changed-only results = %+v", results)
+	}
+}
+
+func TestReplayInvalidCandidate(t *testing.T) {
+	engine, _ := newReplayEngine(t)
+	candidate, _ := engine.Workflow("Adult")
+	candidate.Transitions = []Transition{{FromStep: "start", ToStep: "adult", Rule: RuleSpec{Name: "is_adult"}, FallbackStep: "minor"}}
+	if _, err := engine.Replay(context.Background(), strings.NewReader(""), candidate, &bytes.Buffer{}, ReplayOptions{}); err == nil {
+		t.Error("Replay accepted a candidate using an unknown rule")
+	}
+
+	candidate.Name = "Missing"
+	if _, err := engine.Replay(context.Background(), strings.NewReader(""), candidate, &bytes.Buffer{}, ReplayOptions{}); err == nil {
+		t.Error("Replay accepted a candidate for an unknown workflow")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// executionLimiter bounds the number of workflow runs in progress. Its limit
+// can be changed while runs are waiting; zero or less means no limit.
+type executionLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	changed chan struct{}
+}
+
+func newExecutionLimiter(limit int) *executionLimiter {
+	return &executionLimiter{limit: limit, changed: make(chan struct{})}
+}
+
+// acquire waits for a free slot or for the context to be done.
+func (l *executionLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.limit <= 0 || l.running < l.limit {
+			l.running++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees a slot taken by acquire.
+func (l *executionLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	l.notify()
+}
+
+func (l *executionLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.notify()
+}
+
+// notify wakes every waiting acquire; the caller must hold l.mu.
+func (l *executionLimiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// hostDateLayouts are the layouts wf.parse_date tries when none is given.
+var hostDateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"02/01/2006",
+}
+
+// hostRegexCache caches patterns compiled by wf.regex_match across states.
+var hostRegexCache sync.Map
+
+// hostFunctions are the Go functions exposed to Lua as the 'wf' module.
+var hostFunctions = map[string]lua.LGFunction{
+	"now":         hostNow,
+	"parse_date":  hostParseDate,
+	"age":         hostAge,
+	"regex_match": hostRegexMatch,
+	"json_decode": hostJSONDecode,
+	"json_encode": hostJSONEncode,
+	"log":         hostLog,
+	"lookup":      hostLookup,
+}
+
+// OpenHostLibrary makes the 'wf' module available to scripts, both as a
+// global and through require("wf").
+func OpenHostLibrary(l *lua.LState) {
+	mod := l.SetFuncs(l.NewTable(), hostFunctions)
+	l.SetGlobal("wf", mod)
+	l.PreloadModule("wf", func(l *lua.LState) int {
+		l.Push(mod)
+		return 1
+	})
+}
+
+// wf.now() returns the current time in Unix seconds.
+func hostNow(l *lua.LState) int {
+	l.Push(lua.LNumber(time.Now().Unix()))
+	return 1
+}
+
+// wf.parse_date(value [, layout]) parses a date using a Go time layout, or a
+// few common layouts if none is given, and returns it in Unix seconds. On
+// failure it returns nil and an error message.
+func hostParseDate(l *lua.LState) int {
+	value := l.CheckString(1)
+	layout := l.OptString(2, "")
+
+	t, err := parseHostDate(value, layout)
+	if err != nil {
+		l.Push(lua.LNil)
+		l.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	l.Push(lua.LNumber(t.Unix()))
+	return 1
+}
+
+// wf.age(date) returns the number of whole years between a date, given as
+// a string or in Unix seconds, and now.
+func hostAge(l *lua.LState) int {
+	var born time.Time
+	switch v := l.CheckAny(1).(type) {
+	case lua.LNumber:
+		born = time.Unix(int64(v), 0)
+	case lua.LString:
+		t, err := parseHostDate(string(v), "")
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		born = t
+	default:
+		l.ArgError(1, "date string or number expected")
+		return 0
+	}
+
+	l.Push(lua.LNumber(yearsBetween(born, time.Now())))
+	return 1
+}
+
+// wf.regex_match(pattern, value) reports whether value matches the pattern.
+func hostRegexMatch(l *lua.LState) int {
+	pattern := l.CheckString(1)
+	value := l.CheckString(2)
+
+	re, err := compileHostRegex(pattern)
+	if err != nil {
+		l.RaiseError("invalid pattern '%s': %v", pattern, err)
+		return 0
+	}
+
+	l.Push(lua.LBool(re.MatchString(value)))
+	return 1
+}
+
+// wf.json_decode(text) converts a JSON document to a Lua value. On failure it
+// returns nil and an error message.
+func hostJSONDecode(l *lua.LState) int {
+	var v any
+	if err := json.Unmarshal([]byte(l.CheckString(1)), &v); err != nil {
+		l.Push(lua.LNil)
+		l.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	l.Push(GoValueToLua(l, v))
+	return 1
+}
+
+// wf.json_encode(value) converts a Lua value to a JSON string.
+func hostJSONEncode(l *lua.LState) int {
+	data, err := json.Marshal(LuaValueToGo(l.CheckAny(1)))
+	if err != nil {
+		l.RaiseError("failed to encode value: %v", err)
+		return 0
+	}
+
+	l.Push(lua.LString(data))
+	return 1
+}
+
+// hostLoggerKey is the registry field holding the logger wf.log writes to.
+const hostLoggerKey = "wf.logger"
+
+// setHostLogger sets the logger wf.log writes to in a state; nil restores
+// the default logger.
+func setHostLogger(l *lua.LState, logger *slog.Logger) {
+	registry := l.Get(lua.RegistryIndex)
+	if logger == nil {
+		l.SetField(registry, hostLoggerKey, lua.LNil)
+		return
+	}
+	ud := l.NewUserData()
+	ud.Value = logger
+	l.SetField(registry, hostLoggerKey, ud)
+}
+
+// wf.log(...) writes its arguments to the engine log as one info record,
+// with the workflow, instance id and step of the script's caller.
+func hostLog(l *lua.LState) int {
+	parts := make([]string, 0, l.GetTop())
+	for i := 1; i <= l.GetTop(); i++ {
+		parts = append(parts, l.ToStringMeta(l.Get(i)).String())
+	}
+
+	logger := slog.Default()
+	if ud, ok := l.GetField(l.Get(lua.RegistryIndex), hostLoggerKey).(*lua.LUserData); ok {
+		logger = ud.Value.(*slog.Logger)
+	}
+	logger.Info(strings.Join(parts, " "), "source", "lua")
+	return 0
+}
+
+// wf.lookup(table, path) follows a dotted path such as "address.country" or
+// "items.1.sku" into nested tables and returns the value found there, or nil
+// if any part of the path is missing.
+func hostLookup(l *lua.LState) int {
+	var current lua.LValue = l.CheckTable(1)
+	path := l.CheckString(2)
+
+	for _, key := range strings.Split(path, ".") {
+		table, ok := current.(*lua.LTable)
+		if !ok {
+			current = lua.LNil
+			break
+		}
+
+		if index, err := strconv.Atoi(key); err == nil {
+			current = table.RawGetInt(index)
+		} else {
+			current = table.RawGetString(key)
+		}
+	}
+
+	l.Push(current)
+	return 1
+}
+
+// parseHostDate parses value with the given layout, or with each of the
+// default layouts in turn when layout is empty.
+func parseHostDate(value, layout string) (time.Time, error) {
+	if layout != "" {
+		return time.Parse(layout, value)
+	}
+
+	for _, candidate := range hostDateLayouts {
+		if t, err := time.Parse(candidate, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format: %q", value)
+}
+
+// yearsBetween returns the number of full years from one time to another.
+func yearsBetween(from, to time.Time) int {
+	years := to.Year() - from.Year()
+	if to.Month() < from.Month() || (to.Month() == from.Month() && to.Day() < from.Day()) {
+		years--
+	}
+	return years
+}
+
+func compileHostRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := hostRegexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	hostRegexCache.Store(pattern, re)
+	return re, nil
+}
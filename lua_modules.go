@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// luaLibDir is the directory, relative to the rules directory, holding shared
+// Lua modules that rules can load with require.
+const luaLibDir = "lib"
+
+// luaModuleName matches module names such as "text" or "checks.identity".
+var luaModuleName = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+// acquireState takes a state from the pool with require wired to the shared
+// library directory on behalf of scriptName, and wf.log writing to the
+// context's logger. Scripts run in the state stop when the context is done.
+// The returned function unloads the modules the script required and returns
+// the state to the pool.
+func (l *LuaRuleEngine) acquireState(ctx context.Context, scriptName string) (*lua.LState, func()) {
+	start := time.Now()
+	state := l.luaPool.Get()
+	l.metrics.observePoolWait(time.Since(start))
+	cleanup := l.enableRequire(state, scriptName)
+	setHostLogger(state, LoggerFromContext(ctx))
+	state.SetContext(ctx)
+
+	return state, func() {
+		state.RemoveContext()
+		cleanup()
+		setHostLogger(state, nil)
+		l.luaPool.Put(state)
+	}
+}
+
+// enableRequire installs a package loader that resolves modules from the
+// library directory, records them as dependencies of scriptName and serves
+// them from the compiled library cache. Modules are unloaded again by the
+// returned function so that the next script sees the current version.
+func (l *LuaRuleEngine) enableRequire(state *lua.LState, scriptName string) func() {
+	registry := state.Get(lua.RegistryIndex)
+	loaders, ok := state.GetField(registry, "_LOADERS").(*lua.LTable)
+	if !ok {
+		return func() {}
+	}
+
+	var required []string
+	loaders.RawSetInt(2, state.NewFunction(func(ls *lua.LState) int {
+		name := ls.CheckString(1)
+
+		proto, err := l.getOrCreateLibProto(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			ls.Push(lua.LString(fmt.Sprintf("no module '%s' in %s", name, filepath.Join(l.rulesDir, luaLibDir))))
+			return 1
+		}
+		if err != nil {
+			ls.RaiseError("failed to load module '%s': %v", name, err)
+			return 0
+		}
+
+		l.recordDependency(scriptName, name)
+		required = append(required, name)
+		ls.Push(ls.NewFunctionFromProto(proto))
+		return 1
+	}))
+
+	return func() {
+		loaded := state.GetField(registry, "_LOADED")
+		for _, name := range required {
+			state.SetField(loaded, name, lua.LNil)
+		}
+		loaders.RawSetInt(2, lua.LNil)
+	}
+}
+
+// getOrCreateLibProto returns the compiled form of a shared library module.
+func (l *LuaRuleEngine) getOrCreateLibProto(name string) (*lua.FunctionProto, error) {
+	if !luaModuleName.MatchString(name) {
+		return nil, fmt.Errorf("invalid module name '%s'", name)
+	}
+
+	return l.getOrCompile(l.libs, name, l.libPath(name))
+}
+
+// libPath maps a module name such as "checks.identity" to its file.
+func (l *LuaRuleEngine) libPath(name string) string {
+	rel := strings.ReplaceAll(name, ".", string(filepath.Separator)) + ".lua"
+	return filepath.Join(l.rulesDir, luaLibDir, rel)
+}
+
+// recordDependency notes that a script required a library module.
+func (l *LuaRuleEngine) recordDependency(scriptName, libName string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.deps[scriptName] == nil {
+		l.deps[scriptName] = make(map[string]struct{})
+	}
+	l.deps[scriptName][libName] = struct{}{}
+}
+
+// invalidateStaleLibraries checks the libraries a script depends on and
+// invalidates any that changed on disk since they were compiled.
+func (l *LuaRuleEngine) invalidateStaleLibraries(scriptName string) {
+	l.mu.RLock()
+	var libs []string
+	for lib := range l.deps[scriptName] {
+		libs = append(libs, lib)
+	}
+	l.mu.RUnlock()
+
+	for _, lib := range libs {
+		info, err := os.Stat(l.libPath(lib))
+
+		l.mu.RLock()
+		cached, ok := l.libs[lib]
+		l.mu.RUnlock()
+
+		if ok && err == nil && ruleCacheFresh(cached.modTime, cached.compiledAt, info, l.ttl()) {
+			continue
+		}
+		l.InvalidateLibrary(lib)
+	}
+}
+
+// InvalidateLibrary drops a library module from the cache along with every
+// compiled rule and action that depends on it.
+func (l *LuaRuleEngine) InvalidateLibrary(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.libs, name)
+	for script, libs := range l.deps {
+		if _, ok := libs[name]; ok {
+			delete(l.cache, script)
+			delete(l.deps, script)
+		}
+	}
+}
@@ -12,24 +12,75 @@ type LuaStatePool struct {
 	lock sync.Mutex
 }
 
-// NewLuaStatePool creates a new pool with a given size.
+// NewLuaStatePool creates a new pool with a given size. Every state is
+// sandboxed and has the 'wf' host library loaded.
 func NewLuaStatePool(size int) *LuaStatePool {
 	p := &LuaStatePool{
 		pool: make(chan *lua.LState, size),
 	}
 
 	for range size {
-		p.pool <- lua.NewState()
+		p.pool <- NewSandboxedState()
 	}
 
 	return p
 }
 
+// sandboxLibs are the standard libraries opened in sandboxed states. The io,
+// os and debug libraries are left out so scripts cannot reach the host.
+var sandboxLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.LoadLibName, lua.OpenPackage},
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+	{lua.CoroutineLibName, lua.OpenCoroutine},
+}
+
+// NewSandboxedState creates a Lua state without file or OS access. Modules
+// can only be loaded through require once a rule engine installs its loader.
+func NewSandboxedState() *lua.LState {
+	l := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range sandboxLibs {
+		l.Push(l.NewFunction(lib.open))
+		l.Push(lua.LString(lib.name))
+		l.Call(1, 0)
+	}
+
+	for _, name := range []string{"dofile", "loadfile"} {
+		l.SetGlobal(name, lua.LNil)
+	}
+
+	// Drop the default file-system loader, keeping package.preload.
+	pkg := l.GetGlobal(lua.LoadLibName)
+	l.SetField(pkg, "path", lua.LString(""))
+	if loaders, ok := l.GetField(pkg, "loaders").(*lua.LTable); ok {
+		loaders.RawSetInt(2, lua.LNil)
+	}
+
+	OpenHostLibrary(l)
+	return l
+}
+
 // Get retrieves a Lua state from the pool.
 func (p *LuaStatePool) Get() *lua.LState {
 	return <-p.pool
 }
 
+// Size returns the number of states in the pool.
+func (p *LuaStatePool) Size() int {
+	return cap(p.pool)
+}
+
+// InUse returns the number of states taken from the pool and not yet
+// returned.
+func (p *LuaStatePool) InUse() int {
+	return cap(p.pool) - len(p.pool)
+}
+
 // Put returns a Lua state to the pool.
 func (p *LuaStatePool) Put(l *lua.LState) {
 	// A basic check to prevent a nil state from being returned.
@@ -59,11 +110,30 @@ func LMapToTable(l *lua.LState, data map[string]any) *lua.LTable {
 	return table
 }
 
-// GoValueToLua converts a Go value to a Lua value.
+// luaConvertible reports whether GoValueToLua turns v into a Lua value
+// other than nil.
+func luaConvertible(v any) bool {
+	switch v.(type) {
+	case int, int64, float32, float64, string, bool, map[string]any, []any, []string:
+		return true
+	default:
+		return false
+	}
+}
+
+// GoValueToLua converts a Go value to a Lua value. Values of types that
+// luaConvertible does not accept become nil.
 func GoValueToLua(l *lua.LState, v any) lua.LValue {
+	if !luaConvertible(v) {
+		return lua.LNil
+	}
 	switch val := v.(type) {
 	case int:
 		return lua.LNumber(val)
+	case int64:
+		return lua.LNumber(val)
+	case float32:
+		return lua.LNumber(val)
 	case float64:
 		return lua.LNumber(val)
 	case string:
@@ -72,8 +142,110 @@ func GoValueToLua(l *lua.LState, v any) lua.LValue {
 		return lua.LBool(val)
 	case map[string]any:
 		return LMapToTable(l, val)
-	// Add more type conversions as needed.
+	case []any:
+		table := l.NewTable()
+		for _, item := range val {
+			table.Append(GoValueToLua(l, item))
+		}
+		return table
+	case []string:
+		table := l.NewTable()
+		for _, item := range val {
+			table.Append(lua.LString(item))
+		}
+		return table
+	}
+	return lua.LNil
+}
+
+// restoreGoValues fixes up updated, data as read back from a script, for
+// what the round trip through Lua loses. Entries of data that GoValueToLua
+// turns into nil, which the script never saw and so cannot have removed,
+// are copied back, and lists left empty, which Lua cannot tell apart from
+// empty tables, stay lists.
+func restoreGoValues(data, updated map[string]any) {
+	for k, v := range data {
+		u, ok := updated[k]
+		if !ok {
+			if !luaConvertible(v) {
+				updated[k] = v
+			}
+			continue
+		}
+		updated[k] = restoreGoValue(v, u)
+	}
+}
+
+// restoreGoValue returns updated, a value read back from a script that was
+// original before the script ran, with empty tables given the type of the
+// original value.
+func restoreGoValue(original, updated any) any {
+	switch u := updated.(type) {
+	case map[string]any:
+		switch o := original.(type) {
+		case map[string]any:
+			restoreGoValues(o, u)
+		case []any:
+			if len(u) == 0 {
+				return []any{}
+			}
+		case []string:
+			if len(u) == 0 {
+				return []string{}
+			}
+		}
+	case []any:
+		if o, ok := original.([]any); ok {
+			for i := range min(len(o), len(u)) {
+				u[i] = restoreGoValue(o[i], u[i])
+			}
+		}
+	}
+	return updated
+}
+
+// LTableToMap converts a Lua table with string keys to a Go map.
+// Entries with non-string keys are ignored.
+func LTableToMap(table *lua.LTable) map[string]any {
+	data := make(map[string]any)
+	table.ForEach(func(key, value lua.LValue) {
+		if k, ok := key.(lua.LString); ok {
+			data[string(k)] = LuaValueToGo(value)
+		}
+	})
+	return data
+}
+
+// LuaValueToGo converts a Lua value to a Go value. Numbers become float64 to
+// match values decoded from JSON, and tables become either []any (sequences)
+// or map[string]any.
+func LuaValueToGo(v lua.LValue) any {
+	switch val := v.(type) {
+	case lua.LBool:
+		return bool(val)
+	case lua.LNumber:
+		return float64(val)
+	case lua.LString:
+		return string(val)
+	case *lua.LTable:
+		if n := val.Len(); n > 0 && luaTableSize(val) == n {
+			items := make([]any, 0, n)
+			for i := 1; i <= n; i++ {
+				items = append(items, LuaValueToGo(val.RawGetInt(i)))
+			}
+			return items
+		}
+		return LTableToMap(val)
 	default:
-		return lua.LNil
+		return nil
 	}
-}
\ No newline at end of file
+}
+
+// luaTableSize counts every entry in a table, array part and hash part.
+func luaTableSize(table *lua.LTable) int {
+	size := 0
+	table.ForEach(func(_, _ lua.LValue) {
+		size++
+	})
+	return size
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/yuin/gopher-lua/parse"
@@ -16,51 +17,86 @@ import (
 type LuaRuleEngine struct {
 	luaPool  *LuaStatePool
 	rulesDir string
-	rules    map[string]lua.LValue
-	cache    map[string]*lua.FunctionProto
+	cache    map[string]*compiledScript
+	libs     map[string]*compiledScript
+	deps     map[string]map[string]struct{}
+	cacheTTL time.Duration
+	metrics  *EngineMetrics
 	mu       sync.RWMutex
 }
 
+// compiledScript is a compiled rule, action or library together with the
+// modification time of the file it was compiled from.
+type compiledScript struct {
+	proto      *lua.FunctionProto
+	modTime    time.Time
+	compiledAt time.Time
+}
+
 // NewLuaRuleEngine creates a new Lua-based rule engine
 func NewLuaRuleEngine(pool *LuaStatePool, rulesDir string) *LuaRuleEngine {
 	return &LuaRuleEngine{
 		luaPool:  pool,
 		rulesDir: rulesDir,
-		rules:    make(map[string]lua.LValue),
-		cache:    make(map[string]*lua.FunctionProto),
+		cache:    make(map[string]*compiledScript),
+		libs:     make(map[string]*compiledScript),
+		deps:     make(map[string]map[string]struct{}),
 	}
 }
 
+// Language returns the rule language
+func (l *LuaRuleEngine) Language() string {
+	return LanguageLua
+}
+
+// Extensions returns the rule file extensions
+func (l *LuaRuleEngine) Extensions() []string {
+	return []string{".lua"}
+}
+
+// HasRule reports whether the rule has a Lua file
+func (l *LuaRuleEngine) HasRule(name string) bool {
+	_, err := os.Stat(filepath.Join(l.rulesDir, fmt.Sprintf("%s.lua", name)))
+	return err == nil
+}
+
 // Evaluate executes the Lua script and returns the boolean result.
 func (l *LuaRuleEngine) Evaluate(ctx context.Context, ruleName string, data map[string]any) (bool, error) {
-	// The "pass" rule is handled in-memory
-	if ruleName == "pass" {
-		return true, nil
-	}
+	result, _, err := l.evaluate(ctx, ruleName, data, false)
+	return result, err
+}
 
-	// Get a state from the pool.
-	state := l.luaPool.Get()
-	defer l.luaPool.Put(state)
+// EvaluateMutating executes the Lua script and returns the boolean result
+// together with the data as modified by the rule. A rule may change the table
+// it receives in place, or return a table of updates as a second value.
+func (l *LuaRuleEngine) EvaluateMutating(ctx context.Context, ruleName string, data map[string]any) (bool, map[string]any, error) {
+	return l.evaluate(ctx, ruleName, data, true)
+}
 
-	// Get or compile the script
-	proto, err := l.getOrCreateProto(ruleName)
-	if err != nil {
-		return false, err
-	}
+// SetMetrics makes the engine record rule evaluation latency and failures
+// and Lua pool waits and use. It must be called before the engine is used.
+func (l *LuaRuleEngine) SetMetrics(metrics *EngineMetrics) {
+	l.metrics = metrics
+	metrics.watchLuaPool(l.luaPool)
+}
 
-	// Push the function onto the stack
-	lfunc := state.NewFunctionFromProto(proto)
-	state.Push(lfunc)
+// evaluate runs a rule and records its latency and outcome.
+func (l *LuaRuleEngine) evaluate(ctx context.Context, ruleName string, data map[string]any, mutate bool) (bool, map[string]any, error) {
+	start := time.Now()
+	result, updated, err := l.evaluateRule(ctx, ruleName, data, mutate)
+	l.metrics.observeRule(ruleName, start, err)
+	return result, updated, err
+}
 
-	// Execute the script to define functions (like 'check')
-	if err := state.PCall(0, 0, nil); err != nil {
-		return false, fmt.Errorf("failed to execute rule script: %w", err)
-	}
+func (l *LuaRuleEngine) evaluateRule(ctx context.Context, ruleName string, data map[string]any, mutate bool) (bool, map[string]any, error) {
+	// Get a state from the pool.
+	state, release := l.acquireState(ctx, ruleName)
+	defer release()
 
-	// Get the 'check' function from the Lua script.
-	checkFunc := state.GetGlobal("check")
-	if checkFunc.Type() != lua.LTFunction {
-		return false, fmt.Errorf("rule '%s' does not have a 'check' function", ruleName)
+	// Load the script and look up its 'check' function.
+	checkFunc, err := l.loadEntryPoint(state, ruleName, "check")
+	if err != nil {
+		return false, nil, err
 	}
 
 	// Push the data onto the stack as a Lua table.
@@ -68,75 +104,195 @@ func (l *LuaRuleEngine) Evaluate(ctx context.Context, ruleName string, data map[
 	state.Push(checkFunc)
 	state.Push(luaData)
 
-	// Call the Lua function.
-	err = state.PCall(1, 1, nil)
+	// Call the Lua function. The second result is an optional table of updates.
+	err = state.PCall(1, 2, nil)
 	if err != nil {
-		return false, fmt.Errorf("failed to call lua function 'check': %w", err)
+		return false, nil, luaCallError(state, "failed to call lua function 'check'", err)
 	}
 
-	// Get the result from the stack.
-	result := state.Get(-1)
-	state.Pop(1)
+	// Get the results from the stack.
+	result := state.Get(-2)
+	updates := state.Get(-1)
+	state.Pop(2)
 
 	// Return the result as a boolean.
 	if result.Type() != lua.LTBool {
-		return false, fmt.Errorf("lua function 'check' did not return a boolean")
+		return false, nil, fmt.Errorf("lua function 'check' did not return a boolean")
+	}
+
+	if !mutate {
+		return lua.LVAsBool(result), nil, nil
+	}
+
+	// Read the data back, including any in-place changes, then apply updates.
+	updated := LTableToMap(luaData)
+	restoreGoValues(data, updated)
+	if table, ok := updates.(*lua.LTable); ok {
+		for k, v := range LTableToMap(table) {
+			updated[k] = v
+		}
+	} else if updates != lua.LNil {
+		return false, nil, fmt.Errorf("lua function 'check' returned %s instead of a table of updates", updates.Type())
+	}
+
+	return lua.LVAsBool(result), updated, nil
+}
+
+// RunAction executes the 'run' function of a Lua action script against the
+// instance data. The script may modify the data table in place or return a
+// table of updates; it fails the action by raising an error or by returning
+// nil and an error message.
+func (l *LuaRuleEngine) RunAction(ctx context.Context, actionName string, state *WorkflowState) error {
+	ls, release := l.acquireState(ctx, actionName)
+	defer release()
+
+	runFunc, err := l.loadEntryPoint(ls, actionName, "run")
+	if err != nil {
+		return err
+	}
+
+	luaData := LMapToTable(ls, state.Data)
+	ls.Push(runFunc)
+	ls.Push(luaData)
+	ls.Push(lua.LString(state.CurrentStep))
+
+	if err := ls.PCall(2, 2, nil); err != nil {
+		return luaCallError(ls, "failed to call lua function 'run'", err)
+	}
+
+	result := ls.Get(-2)
+	message := ls.Get(-1)
+	ls.Pop(2)
+
+	if result == lua.LNil && message.Type() == lua.LTString {
+		return fmt.Errorf("%s", message.String())
 	}
 
-	return lua.LVAsBool(result), nil
+	updated := LTableToMap(luaData)
+	restoreGoValues(state.Data, updated)
+	if table, ok := result.(*lua.LTable); ok {
+		for k, v := range LTableToMap(table) {
+			updated[k] = v
+		}
+	}
+
+	if state.Data == nil {
+		state.Data = make(map[string]any)
+	}
+	MergeData(state.Data, DiffData(state.Data, updated))
+	return nil
 }
 
+// loadEntryPoint executes a script in the given state and returns the global
+// function it defines under fnName. The global is cleared first so that a
+// function left behind by a previously executed script is never picked up.
+func (l *LuaRuleEngine) loadEntryPoint(state *lua.LState, scriptName, fnName string) (lua.LValue, error) {
+	// Get or compile the script
+	proto, err := l.getOrCreateProto(scriptName)
+	if err != nil {
+		return nil, err
+	}
+
+	state.SetGlobal(fnName, lua.LNil)
+
+	// Push the function onto the stack
+	lfunc := state.NewFunctionFromProto(proto)
+	state.Push(lfunc)
+
+	// Execute the script to define its functions
+	if err := state.PCall(0, 0, nil); err != nil {
+		return nil, luaCallError(state, fmt.Sprintf("failed to execute script '%s'", scriptName), err)
+	}
+
+	fn := state.GetGlobal(fnName)
+	if fn.Type() != lua.LTFunction {
+		return nil, fmt.Errorf("script '%s' does not have a '%s' function", scriptName, fnName)
+	}
+
+	return fn, nil
+}
+
+// luaCallError wraps an error from running Lua code in a state. A script
+// stopped because the state's context is done reports the context's error,
+// so that callers can tell a timeout from a failing script.
+func luaCallError(state *lua.LState, message string, err error) error {
+	if ctx := state.Context(); ctx != nil && ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", message, ctx.Err())
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
+
+// getOrCreateProto returns the compiled form of a rule or action script,
+// recompiling it when the file, or a library it requires, has changed.
 func (l *LuaRuleEngine) getOrCreateProto(ruleName string) (*lua.FunctionProto, error) {
+	l.invalidateStaleLibraries(ruleName)
+
+	rulePath := filepath.Join(l.rulesDir, fmt.Sprintf("%s.lua", ruleName))
+	return l.getOrCompile(l.cache, ruleName, rulePath)
+}
+
+// getOrCompile returns the cached proto for name, compiling the file at path
+// if it is not cached or has been modified since it was compiled.
+func (l *LuaRuleEngine) getOrCompile(cache map[string]*compiledScript, name, path string) (*lua.FunctionProto, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script file: %w", err)
+	}
+
 	l.mu.RLock()
-	proto, ok := l.cache[ruleName]
+	script, ok := cache[name]
 	l.mu.RUnlock()
 
-	if ok {
-		return proto, nil
+	if ok && ruleCacheFresh(script.modTime, script.compiledAt, info, l.ttl()) {
+		return script.proto, nil
 	}
 
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	// Double-check after acquiring lock
-	if proto, ok := l.cache[ruleName]; ok {
-		return proto, nil
+	if script, ok := cache[name]; ok && ruleCacheFresh(script.modTime, script.compiledAt, info, l.cacheTTL) {
+		return script.proto, nil
 	}
 
-	// Load the rule script.
-	rulePath := filepath.Join(l.rulesDir, fmt.Sprintf("%s.lua", ruleName))
-	ruleFile, err := os.ReadFile(rulePath)
+	// Load the script.
+	scriptFile, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read rule file: %w", err)
+		return nil, fmt.Errorf("failed to read script file: %w", err)
 	}
 
 	// Compile the script
-	reader := strings.NewReader(string(ruleFile))
-	chunk, err := parse.Parse(reader, ruleName)
+	reader := strings.NewReader(string(scriptFile))
+	chunk, err := parse.Parse(reader, name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse lua script: %w", err)
 	}
 
-	compiled, err := lua.Compile(chunk, ruleName)
+	compiled, err := lua.Compile(chunk, name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to compile lua script: %w", err)
 	}
 
-	l.cache[ruleName] = compiled
+	cache[name] = &compiledScript{proto: compiled, modTime: info.ModTime(), compiledAt: time.Now()}
 	return compiled, nil
 }
 
-// RegisterRule registers a new rule with the engine
+// SetCacheTTL sets how long compiled scripts and libraries are kept before
+// they are recompiled.
+func (l *LuaRuleEngine) SetCacheTTL(ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cacheTTL = ttl
+}
+
+func (l *LuaRuleEngine) ttl() time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.cacheTTL
+}
+
+// RegisterRule is not supported: Lua rules are read from .lua files in the
+// rules directory, and Go rules belong to the Go rule engine.
 func (l *LuaRuleEngine) RegisterRule(name string, rule any) error {
-	// In a more advanced implementation, this could register functions directly
-	// For now, we'll just validate that it's a function if it's a Lua value
-	if lv, ok := rule.(lua.LValue); ok {
-		if lv.Type() == lua.LTFunction {
-			l.rules[name] = lv
-			return nil
-		}
-		return fmt.Errorf("rule must be a Lua function")
-	}
-	
-	return fmt.Errorf("unsupported rule type")
-}
\ No newline at end of file
+	return fmt.Errorf("lua rules are read from the rules directory, not registered")
+}
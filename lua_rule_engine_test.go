@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLuaRuleTimeout(t *testing.T) {
+	engine, err := newTestEngine(t, EngineOptions{LuaPoolSize: 1, WorkflowTimeout: 200 * time.Millisecond},
+		map[string]string{
+			"loop.yml": `
+name: Loop
+start_step: start
+transitions:
+  - from: start
+    to: done
+    rule: spin
+    fallback_to: done
+`,
+			"adult.yml": `
+name: Adult
+start_step: start
+transitions:
+  - from: start
+    to: adult
+    rule: is_over_18
+    fallback_to: minor
+`,
+		},
+		map[string]string{
+			"spin.lua":       "function check(data) while true do end end",
+			"is_over_18.lua": "function check(data) return data.age >= 18 end",
+		})
+	if err != nil {
+		t.Fatalf("NewWorkflowEngine: %v", err)
+	}
+
+	start := time.Now()
+	state := &WorkflowState{Data: map[string]any{}}
+	err = engine.RunWorkflow(context.Background(), "Loop", state)
+	elapsed := time.Since(start)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("rule stopped after %v, want about 200ms", elapsed)
+	}
+	if inUse := engine.luaPool.InUse(); inUse != 0 {
+		t.Errorf("%d Lua states still in use", inUse)
+	}
+
+	// The state stopped by the timeout is returned to the pool and still works.
+	state = &WorkflowState{Data: map[string]any{"age": 30}}
+	if err := engine.RunWorkflow(context.Background(), "Adult", state); err != nil {
+		t.Fatalf("RunWorkflow after a timeout: %v", err)
+	}
+	if state.CurrentStep != "adult" {
+		t.Errorf("instance ended in %q, want adult", state.CurrentStep)
+	}
+}
+
+func TestLuaRuleCancelled(t *testing.T) {
+	pool := NewLuaStatePool(1)
+	defer pool.Close()
+	rules := writeTestFiles(t, map[string]string{"spin.lua": "function check(data) while true do end end"})
+	engine := NewLuaRuleEngine(pool, rules)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err := engine.Evaluate(ctx, "spin", map[string]any{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestLuaRuleRoundTrip(t *testing.T) {
+	pool := NewLuaStatePool(1)
+	defer pool.Close()
+	rules := writeTestFiles(t, map[string]string{
+		"touch.lua": "function check(data) data.seen = true return true end",
+		"clear.lua": "function check(data) data.tags = {} data.names = {} return true end",
+	})
+	engine := NewLuaRuleEngine(pool, rules)
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		rule string
+		data map[string]any
+		want DataDiff
+	}{
+		{
+			name: "unchanged values",
+			rule: "touch",
+			data: map[string]any{
+				"tags":    []any{},
+				"names":   []string{},
+				"nested":  map[string]any{"items": []any{}, "created": created},
+				"rows":    []any{map[string]any{"items": []string{}}},
+				"empty":   map[string]any{},
+				"created": created,
+				"count":   3,
+			},
+			want: DataDiff{"seen": {New: true}},
+		},
+		{
+			name: "cleared lists",
+			rule: "clear",
+			data: map[string]any{"tags": []any{"a"}, "names": []string{"b"}},
+			want: DataDiff{
+				"tags":  {Old: []any{"a"}, New: []any{}},
+				"names": {Old: []string{"b"}, New: []string{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, updated, err := engine.EvaluateMutating(context.Background(), tt.rule, tt.data)
+			if err != nil {
+				t.Fatalf("EvaluateMutating: %v", err)
+			}
+			if diff := DiffData(tt.data, updated); !reflect.DeepEqual(diff, tt.want) {
+				t.Errorf("diff = %#v, want %#v", diff, tt.want)
+			}
+		})
+	}
+}
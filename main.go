@@ -1,46 +1,86 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 var (
-	engine      *WorkflowEngine
-	storage     WorkflowStorage
-	ruleStorage RuleStorage
+	engine       *WorkflowEngine
+	storage      WorkflowStorage
+	ruleStorage  RuleStorage
+	stateStorage StateStorage
+	settings     *Settings
+	analytics    *Analytics
+	tracer       *Tracer
+	broadcaster  *EventBroadcaster
+	logLevel     = new(slog.LevelVar)
+	metrics      = NewMetrics()
 )
 
 func main() {
-	// Load config
-	cfg, err := LoadConfig("config.txt")
+	if err := runCLI(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// serve starts the web interface and API
+func serve(cfg *Config) error {
+	var err error
+	analytics, err = NewAnalytics(cfg.AnalyticsFile)
 	if err != nil {
-		log.Printf("Failed to load config, using defaults: %v", err)
-		cfg = &Config{
-			WorkflowsDir: "./workflows",
-			RulesDir:     "./rules",
-			StatesDir:    "./states",
-			LuaPoolSize:  10,
-		}
+		return err
 	}
+	engine.AddEventHandler(analytics)
 
-	// Initialize engine
-	engine, err = NewWorkflowEngine(EngineOptions{
-		WorkflowsDir: cfg.WorkflowsDir,
-		RulesDir:     cfg.RulesDir,
-		LuaPoolSize:  cfg.LuaPoolSize,
-	})
+	broadcaster = NewEventBroadcaster()
+	engine.AddEventHandler(broadcaster)
+
+	outbox, err := NewOutbox(cfg.OutboxDir)
 	if err != nil {
-		log.Fatalf("Failed to initialize engine: %v", err)
+		return err
 	}
+	engine.SetOutbox(outbox)
 
-	// Initialize and set storage
-	storage = NewFileWorkflowStorage(cfg.WorkflowsDir)
-	ruleStorage = NewFileRuleStorage(cfg.RulesDir)
-	engine.SetStorage(storage)
-	engine.SetStateStorage(NewFileStateStorage(cfg.StatesDir))
+	if cfg.WebhooksFile != "" {
+		webhooks, err := LoadWebhooks(cfg.WebhooksFile)
+		if err != nil {
+			return err
+		}
+		webhookHandler, err := NewWebhookHandler(webhooks, cfg.WebhookLogFile)
+		if err != nil {
+			return err
+		}
+		defer webhookHandler.Close()
+		engine.AddEventHandler(webhookHandler)
+	}
+
+	// Stop on SIGINT or SIGTERM, saving the analytics before exiting.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	saved := make(chan struct{})
+	go func() {
+		analytics.Run(ctx, analyticsSaveInterval)
+		close(saved)
+	}()
+	delivered := make(chan struct{})
+	go func() {
+		outbox.Run(ctx, cfg.OutboxWorkers)
+		close(delivered)
+	}()
 
 	// Create HTTP server
 	http.HandleFunc("/", homeHandler)
@@ -57,14 +97,141 @@ func main() {
 	http.HandleFunc("/api/workflows/", workflowAPIHandler)
 	http.HandleFunc("/api/rules", rulesAPIHandler)
 	http.HandleFunc("/api/rules/", ruleAPIHandler)
+	http.HandleFunc("/api/registry", registryAPIHandler)
+	http.HandleFunc("/api/settings", settingsAPIHandler)
+	http.HandleFunc("/api/instances/", instanceAPIHandler)
+	http.HandleFunc("/api/analytics", analyticsAPIHandler)
+	http.HandleFunc("/api/analytics/funnel", funnelAPIHandler)
+	http.HandleFunc("/api/events/stream", eventStreamAPIHandler)
+	http.Handle("/metrics", metrics)
 
 	// Static file serving
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
 
 	// Start server
-	port := "8080"
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	server := &http.Server{
+		Addr:         cfg.ListenAddr,
+		Handler:      traceRequests(requireToken(cfg.AuthToken, http.DefaultServeMux)),
+		ReadTimeout:  time.Duration(cfg.ReadTimeoutSeconds) * time.Second,
+		WriteTimeout: time.Duration(cfg.WriteTimeoutSeconds) * time.Second,
+	}
+	// End event streams so that they don't hold up the shutdown.
+	server.RegisterOnShutdown(broadcaster.Close)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if cfg.TLSCertFile != "" {
+		slog.Info("server starting", "addr", cfg.ListenAddr, "tls", true)
+		err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		slog.Info("server starting", "addr", cfg.ListenAddr)
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	<-saved
+	<-delivered
+	slog.Info("server stopped")
+	return nil
+}
+
+// shutdownTimeout is how long requests in progress get to finish when the
+// server is stopped.
+const shutdownTimeout = 10 * time.Second
+
+// traceRequests records a server span for each API request, continuing the
+// trace given in the request's traceparent header. Workflow runs started by
+// the request are traced as its children.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracer == nil || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		if sc, err := ParseTraceparent(r.Header.Get("traceparent")); err == nil {
+			ctx = ContextWithSpanContext(ctx, sc)
+		}
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path, SpanKindServer,
+			"http.request.method", r.Method, "url.path", r.URL.Path)
+		defer span.Finish()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		span.SetAttributes("http.response.status_code", recorder.status)
+		if recorder.status >= 500 {
+			span.RecordError(errors.New(http.StatusText(recorder.status)))
+		}
+	})
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, to flush
+// event streams.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// tokenCookie is the cookie carrying the auth token for the UI, whose
+// pages, and their event stream, can't send an Authorization header.
+const tokenCookie = "myworkflow_token"
+
+// requireToken protects the API and metrics with a bearer token, sent in
+// the Authorization header or in the token cookie. Pages and static files
+// stay public; opening a page with the token in a token query parameter
+// sets the cookie and redirects to the page without it. An empty token
+// disables the check.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+
+	expected := []byte(token)
+	valid := func(value string) bool {
+		return subtle.ConstantTimeCompare([]byte(value), expected) == 1
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/metrics" {
+			if query := r.URL.Query(); query.Has("token") && valid(query.Get("token")) {
+				http.SetCookie(w, &http.Cookie{Name: tokenCookie, Value: token, Path: "/",
+					HttpOnly: true, SameSite: http.SameSiteStrictMode, Secure: r.TLS != nil})
+				query.Del("token")
+				u := *r.URL
+				u.RawQuery = query.Encode()
+				http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bearer, hasBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		cookie, err := r.Cookie(tokenCookie)
+		if !(hasBearer && valid(bearer)) && !(err == nil && valid(cookie.Value)) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // Handlers for HTML pages
@@ -104,6 +271,104 @@ func ruleEditorHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "./static/rule-editor.html")
 }
 
+// initLogger creates the logger described by the configuration and makes
+// it the default, so that the standard log package writes to it too
+func initLogger(cfg *Config) (*slog.Logger, error) {
+	level, err := ParseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	logLevel.Set(level)
+
+	var out io.Writer = os.Stderr
+	if cfg.LogFile != "" {
+		out, err = OpenRotatingFile(cfg.LogFile, int64(cfg.LogMaxSizeMB)<<20, cfg.LogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger, err := NewLogger(out, cfg.LogFormat, logLevel)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// initTracer creates the tracer for the configured exporter, or returns nil
+// if tracing is off
+func initTracer(cfg *Config) (*Tracer, error) {
+	switch cfg.TracingExporter {
+	case TracingStdout:
+		return NewTracer(NewWriterExporter(cfg.TracingServiceName, os.Stdout)), nil
+	case TracingFile:
+		exporter, err := NewFileExporter(cfg.TracingServiceName, cfg.TracingFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewTracer(exporter), nil
+	case TracingOTLP:
+		return NewTracer(NewOTLPExporter(cfg.TracingServiceName, cfg.TracingOTLPEndpoint)), nil
+	default:
+		return nil, nil
+	}
+}
+
+// initEngine creates the logger, engine and storage from the configuration
+func initEngine(cfg *Config) error {
+	logger, err := initLogger(cfg)
+	if err != nil {
+		return err
+	}
+	if tracer, err = initTracer(cfg); err != nil {
+		return err
+	}
+
+	engine, err = NewWorkflowEngine(EngineOptions{
+		WorkflowsDir:            cfg.WorkflowsDir,
+		RulesDir:                cfg.RulesDir,
+		LuaPoolSize:             cfg.LuaPoolSize,
+		WorkflowTimeout:         time.Duration(cfg.WorkflowTimeoutSeconds) * time.Second,
+		MaxConcurrentExecutions: cfg.MaxConcurrentExecutions,
+		RuleCacheTTL:            time.Duration(cfg.RuleCacheTTLSeconds) * time.Second,
+		Logger:                  logger,
+		Metrics:                 NewEngineMetrics(metrics),
+		Tracer:                  tracer,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Initialize and set storage
+	storage = NewFileWorkflowStorage(cfg.WorkflowsDir)
+	fileRules := NewFileRuleStorage(cfg.RulesDir)
+	fileRules.SetVersioning(cfg.RuleVersioning)
+	ruleStorage = fileRules
+	engine.SetStorage(storage)
+	if cfg.StorageBackend == StorageMemory {
+		stateStorage = NewMemoryStateStorage()
+	} else {
+		stateStorage = NewFileStateStorage(cfg.StatesDir)
+	}
+	engine.SetStateStorage(stateStorage)
+	return nil
+}
+
+// applySettings applies the settings that can change while the server is
+// running
+func applySettings(cfg *Config) {
+	if level, err := ParseLogLevel(cfg.LogLevel); err == nil {
+		logLevel.Set(level)
+	}
+	engine.SetWorkflowTimeout(time.Duration(cfg.WorkflowTimeoutSeconds) * time.Second)
+	engine.SetMaxConcurrentExecutions(cfg.MaxConcurrentExecutions)
+	engine.SetRuleCacheTTL(time.Duration(cfg.RuleCacheTTLSeconds) * time.Second)
+	if fileRules, ok := ruleStorage.(*FileRuleStorage); ok {
+		fileRules.SetVersioning(cfg.RuleVersioning)
+	}
+}
+
 // API handlers
 func workflowsAPIHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -124,6 +389,24 @@ func workflowAPIHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if name, ok := strings.CutSuffix(path, "/execute"); ok {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		executeWorkflow(w, r, name)
+		return
+	}
+
+	if name, ok := strings.CutSuffix(path, "/batch"); ok {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		batchWorkflow(w, r, name)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		getWorkflow(w, r, path)
@@ -169,6 +452,70 @@ func ruleAPIHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func registryAPIHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getRegistry(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func instanceAPIHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract instance ID from URL path
+	path := strings.TrimPrefix(r.URL.Path, "/api/instances/")
+	id, ok := strings.CutSuffix(path, "/trace")
+	if !ok || id == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getInstanceTrace(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func analyticsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getAnalytics(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func funnelAPIHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getFunnel(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func eventStreamAPIHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		streamEvents(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func settingsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getSettings(w, r)
+	case http.MethodPut:
+		updateSettings(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // Implementation of API functions
 func getWorkflows(w http.ResponseWriter, r *http.Request) {
 	workflowNames, err := storage.ListWorkflows(r.Context())
@@ -201,6 +548,8 @@ func getWorkflow(w http.ResponseWriter, r *http.Request, name string) {
 	json.NewEncoder(w).Encode(wf)
 }
 
+// createWorkflow validates a workflow, saves it and registers it with the
+// engine, so that it can be executed straight away.
 func createWorkflow(w http.ResponseWriter, r *http.Request) {
 	var wf Workflow
 	if err := json.NewDecoder(r.Body).Decode(&wf); err != nil {
@@ -208,8 +557,7 @@ func createWorkflow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := storage.SaveWorkflow(r.Context(), wf); err != nil {
-		http.Error(w, "Failed to save workflow", http.StatusInternalServerError)
+	if !saveWorkflow(w, r, wf) {
 		return
 	}
 
@@ -218,20 +566,144 @@ func createWorkflow(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(wf)
 }
 
+// updateWorkflow replaces a workflow like createWorkflow. The name in the
+// body, if given, must match the one in the path.
 func updateWorkflow(w http.ResponseWriter, r *http.Request, name string) {
 	var wf Workflow
 	if err := json.NewDecoder(r.Body).Decode(&wf); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if wf.Name == "" {
+		wf.Name = name
+	}
+	if wf.Name != name {
+		http.Error(w, "Workflow name does not match the path", http.StatusBadRequest)
+		return
+	}
+
+	if !saveWorkflow(w, r, wf) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wf)
+}
+
+// saveWorkflow validates a workflow, saves it to storage and registers it
+// with the engine, replacing the running definition. It writes an error
+// response and returns false if the workflow is invalid or can't be saved.
+func saveWorkflow(w http.ResponseWriter, r *http.Request, wf Workflow) bool {
+	if err := engine.ValidateWorkflow(wf); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid workflow: %v", err), http.StatusBadRequest)
+		return false
+	}
 
 	if err := storage.SaveWorkflow(r.Context(), wf); err != nil {
 		http.Error(w, "Failed to save workflow", http.StatusInternalServerError)
+		return false
+	}
+
+	engine.RegisterWorkflow(wf)
+	return true
+}
+
+// executeWorkflow runs a new instance of a workflow with the JSON object in
+// the request body as its data, and returns the final state. Data rejected by
+// the workflow's input schema is reported field by field with status 422.
+func executeWorkflow(w http.ResponseWriter, r *http.Request, name string) {
+	if _, ok := engine.Workflow(name); !ok {
+		http.Error(w, "Workflow not found", http.StatusNotFound)
+		return
+	}
+
+	var data map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
+	state := &WorkflowState{Data: data}
+	err := engine.RunWorkflow(r.Context(), name, state)
+
+	var validationErr *ValidationError
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(wf)
+	switch {
+	case errors.As(err, &validationErr):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":  "invalid workflow data",
+			"fields": validationErr.Fields,
+		})
+	case err != nil:
+		if state.Error == "" {
+			state.Error = err.Error()
+		}
+		state.Status = StatusFailed
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(state)
+	default:
+		json.NewEncoder(w).Encode(state)
+	}
+}
+
+// batchWorkflow runs a workflow over each row of a CSV file uploaded as the
+// multipart form field "file". The optional fields "types" (column types,
+// as in "age=integer") and "concurrency" control parsing and parallelism.
+// Results are returned as JSON, or as CSV if format=csv is given or the
+// client accepts text/csv.
+func batchWorkflow(w http.ResponseWriter, r *http.Request, name string) {
+	if _, ok := engine.Workflow(name); !ok {
+		http.Error(w, "Workflow not found", http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "CSV file required in field 'file'", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	types, err := ParseColumnTypes(r.FormValue("types"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	concurrency := defaultBatchConcurrency
+	if value := r.FormValue("concurrency"); value != "" {
+		if concurrency, err = strconv.Atoi(value); err != nil || concurrency <= 0 {
+			http.Error(w, "Invalid concurrency", http.StatusBadRequest)
+			return
+		}
+	}
+
+	input, err := ReadBatchCSV(file, types)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := engine.RunBatch(r.Context(), name, input.Rows, concurrency)
+	if err != nil {
+		http.Error(w, "Batch failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.FormValue("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		WriteBatchResultsCSV(w, input.Columns, results)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	WriteBatchResultsJSON(w, results)
 }
 
 func getRules(w http.ResponseWriter, r *http.Request) {
@@ -241,6 +713,14 @@ func getRules(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Go rules have no files; report them from the registry.
+	for _, name := range engine.Registry().RuleNames() {
+		rules = append(rules, Rule{
+			Name:     name,
+			Language: LanguageGo,
+		})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(rules)
 }
@@ -288,3 +768,216 @@ func updateRule(w http.ResponseWriter, r *http.Request, name string) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(rule)
 }
+
+func getRegistry(w http.ResponseWriter, r *http.Request) {
+	registry := engine.Registry()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{
+		"rules":   registry.RuleNames(),
+		"actions": registry.ActionNames(),
+	})
+}
+
+// getInstanceTrace returns the status and trace of a stored instance
+func getInstanceTrace(w http.ResponseWriter, r *http.Request, id string) {
+	state, err := stateStorage.FindState(r.Context(), id)
+	if errors.Is(err, ErrStateNotFound) {
+		http.Error(w, "Instance not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load instance", http.StatusInternalServerError)
+		return
+	}
+
+	trace := state.Trace
+	if trace == nil {
+		trace = []TraceEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":           state.ID,
+		"workflow":     state.Workflow,
+		"status":       state.Status,
+		"current_step": state.CurrentStep,
+		"error":        state.Error,
+		"path":         state.Path,
+		"started_at":   state.StartedAt,
+		"finished_at":  state.FinishedAt,
+		"trace":        trace,
+	})
+}
+
+// analyticsSaveInterval is how often the analytics rollups are saved.
+const analyticsSaveInterval = 10 * time.Second
+
+// getAnalytics reports the executions between the from and to query
+// parameters, of the workflow parameter if given. Dates (2006-01-02) cover
+// whole days; RFC 3339 times are also accepted.
+func getAnalytics(w http.ResponseWriter, r *http.Request) {
+	from, to, err := analyticsRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(analytics.Query(from, to, r.URL.Query().Get("workflow")))
+}
+
+// getFunnel reports the steps, transitions and paths taken by the instances
+// of the workflow query parameter, over the same range as getAnalytics
+func getFunnel(w http.ResponseWriter, r *http.Request) {
+	workflow := r.URL.Query().Get("workflow")
+	if workflow == "" {
+		http.Error(w, "workflow is required", http.StatusBadRequest)
+		return
+	}
+	from, to, err := analyticsRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(analytics.Funnel(from, to, workflow))
+}
+
+// eventStreamHeartbeat is how often an idle event stream gets a comment, to
+// keep proxies from closing it.
+const eventStreamHeartbeat = 15 * time.Second
+
+// streamEvents sends workflow events as Server-Sent Events as they happen,
+// optionally only those of the workflow and instance_id query parameters.
+// The stream ends when the client disconnects, falls too far behind or the
+// server shuts down.
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sub := broadcaster.Subscribe(query.Get("workflow"), query.Get("instance_id"))
+	defer broadcaster.Unsubscribe(sub)
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				if sub.Dropped() {
+					slog.Warn("event stream client fell behind and was dropped", "remote_addr", r.RemoteAddr)
+				}
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// analyticsRange returns the range given by the from and to query
+// parameters, which defaults to the last seven days.
+func analyticsRange(r *http.Request) (from, to time.Time, err error) {
+	query := r.URL.Query()
+	to = time.Now()
+	if value := query.Get("to"); value != "" {
+		if to, err = parseAnalyticsTime(value, true); err != nil {
+			return from, to, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	from = to.Add(-7 * 24 * time.Hour)
+	if value := query.Get("from"); value != "" {
+		if from, err = parseAnalyticsTime(value, false); err != nil {
+			return from, to, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	if to.Sub(from) > analyticsDailyRetention {
+		return from, to, fmt.Errorf("range must not exceed %d days", analyticsDailyRetention/(24*time.Hour))
+	}
+	return from, to, nil
+}
+
+// parseAnalyticsTime parses an RFC 3339 time or a date. A date used as the
+// end of a range means the end of that day.
+func parseAnalyticsTime(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a date (2006-01-02) or an RFC 3339 time")
+	}
+	if end {
+		t = t.Add(24 * time.Hour)
+	}
+	return t, nil
+}
+
+// getSettings returns the current configuration and the settings changed
+// since startup that wait for a restart
+func getSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"settings":         settingsJSON(settings.Config()),
+		"restart_required": settings.RestartRequired(),
+	})
+}
+
+// updateSettings changes the settings given in a JSON object, saves them to
+// the configuration file and applies those that don't need a restart
+func updateSettings(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+	values, err := parseSettingsJSON(body)
+	if err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	update, err := settings.Update(values)
+	if errors.Is(err, ErrInvalidSettings) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"settings":         settingsJSON(settings.Config()),
+		"changed":          update.Changed,
+		"applied":          update.Applied,
+		"restart_required": update.RestartRequired,
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	handler := requireToken("s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	validCookie := &http.Cookie{Name: tokenCookie, Value: "s3cret"}
+
+	tests := []struct {
+		name         string
+		target       string
+		header       string
+		cookie       *http.Cookie
+		wantStatus   int
+		wantLocation string
+		wantCookie   bool
+	}{
+		{name: "api without token", target: "/api/workflows", wantStatus: http.StatusUnauthorized},
+		{name: "api with bearer token", target: "/api/workflows", header: "Bearer s3cret", wantStatus: http.StatusOK},
+		{name: "api with wrong bearer token", target: "/api/workflows", header: "Bearer other", wantStatus: http.StatusUnauthorized},
+		{name: "api with token without bearer", target: "/api/workflows", header: "s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "api with cookie", target: "/api/workflows", cookie: validCookie, wantStatus: http.StatusOK},
+		{name: "api with wrong cookie", target: "/api/workflows", cookie: &http.Cookie{Name: tokenCookie, Value: "other"}, wantStatus: http.StatusUnauthorized},
+		{name: "event stream with cookie", target: "/api/events/stream?workflow=Onboarding", cookie: validCookie, wantStatus: http.StatusOK},
+		{name: "event stream without token", target: "/api/events/stream", wantStatus: http.StatusUnauthorized},
+		{name: "api ignores token parameter", target: "/api/workflows?token=s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "metrics without token", target: "/metrics", wantStatus: http.StatusUnauthorized},
+		{name: "page", target: "/workflows", wantStatus: http.StatusOK},
+		{name: "page with token", target: "/workflows?token=s3cret&name=a", wantStatus: http.StatusSeeOther, wantLocation: "/workflows?name=a", wantCookie: true},
+		{name: "page with wrong token", target: "/?token=other", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			cookies := rec.Result().Cookies()
+			if !tt.wantCookie {
+				if len(cookies) != 0 {
+					t.Errorf("got cookies %v, want none", cookies)
+				}
+				return
+			}
+			if len(cookies) != 1 || cookies[0].Name != tokenCookie || cookies[0].Value != "s3cret" || !cookies[0].HttpOnly {
+				t.Errorf("cookies = %v, want an HttpOnly %s cookie", cookies, tokenCookie)
+			}
+		})
+	}
+}
+
+func TestRequireTokenDisabled(t *testing.T) {
+	handler := requireToken("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/workflows", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestExecuteWorkflowValidation(t *testing.T) {
+	var events []EventKind
+	recorder := EventListenerFunc(func(ctx context.Context, event *WorkflowEvent) error {
+		events = append(events, event.Kind)
+		return nil
+	})
+	workflow := `
+name: Signup
+start_step: start
+input_schema:
+  type: object
+  required: [name, age]
+  properties:
+    name: {type: string}
+    age: {type: integer, minimum: 0}
+transitions:
+  - from: start
+    to: done
+    rule: age >= 18
+    fallback_to: minor
+`
+	testEngine, err := newTestEngine(t, EngineOptions{EventListeners: []EventListener{recorder}}, map[string]string{"signup.yml": workflow}, nil)
+	if err != nil {
+		t.Fatalf("NewWorkflowEngine: %v", err)
+	}
+	previous := engine
+	engine = testEngine
+	t.Cleanup(func() { engine = previous })
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantFields []FieldError
+		wantEvents []EventKind
+	}{
+		{
+			name:       "invalid data",
+			body:       `{"name": 7, "age": -1}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []FieldError{
+				{Field: "age", Message: "must be at least 0"},
+				{Field: "name", Message: "must be of type string, got integer"},
+			},
+			wantEvents: []EventKind{EventWorkflowFailed},
+		},
+		{
+			name:       "valid data",
+			body:       `{"name": "Sam", "age": 30}`,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events = nil
+			req := httptest.NewRequest(http.MethodPost, "/api/workflows/Signup/execute", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			workflowAPIHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var state WorkflowState
+				if err := json.NewDecoder(rec.Body).Decode(&state); err != nil || state.Status != StatusCompleted {
+					t.Errorf("state = %+v, %v, want a completed instance", state, err)
+				}
+				return
+			}
+
+			var body struct {
+				Error  string       `json:"error"`
+				Fields []FieldError `json:"fields"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if body.Error != "invalid workflow data" || !reflect.DeepEqual(body.Fields, tt.wantFields) {
+				t.Errorf("response = %+v, want fields %+v", body, tt.wantFields)
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("events = %v, want %v", events, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestSaveWorkflowAPI(t *testing.T) {
+	testEngine, err := newTestEngine(t, EngineOptions{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewWorkflowEngine: %v", err)
+	}
+	workflowsDir := t.TempDir()
+	previousEngine, previousStorage := engine, storage
+	engine, storage = testEngine, NewFileWorkflowStorage(workflowsDir)
+	t.Cleanup(func() { engine, storage = previousEngine, previousStorage })
+
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		if target == "/api/workflows" {
+			workflowsAPIHandler(rec, req)
+		} else {
+			workflowAPIHandler(rec, req)
+		}
+		return rec
+	}
+	execute := func(age int) string {
+		rec := send(http.MethodPost, "/api/workflows/Adult/execute", fmt.Sprintf(`{"age": %d}`, age))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("execute status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		var state WorkflowState
+		if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		return state.CurrentStep
+	}
+	workflow := func(name, rule string) string {
+		return fmt.Sprintf(`{"name": %q, "start_step": "start", "transitions": [{"from": "start", "to": "adult", "rule": %q, "fallback_to": "minor"}]}`, name, rule)
+	}
+
+	if rec := send(http.MethodPost, "/api/workflows", workflow("Adult", "age >= 18")); rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if step := execute(20); step != "adult" {
+		t.Errorf("created workflow ended in %q, want adult", step)
+	}
+
+	if rec := send(http.MethodPut, "/api/workflows/Adult", workflow("", "age >= 21")); rec.Code != http.StatusOK {
+		t.Fatalf("update status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if step := execute(20); step != "minor" {
+		t.Errorf("updated workflow ended in %q, want minor", step)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		wantErr string
+	}{
+		{name: "unknown rule", method: http.MethodPost, target: "/api/workflows", body: workflow("Other", "is_adult"), wantErr: "rule not found: is_adult"},
+		{name: "invalid expression", method: http.MethodPut, target: "/api/workflows/Adult", body: workflow("Adult", "age >="), wantErr: "unexpected 'end of expression'"},
+		{name: "missing name", method: http.MethodPost, target: "/api/workflows", body: workflow("", "pass"), wantErr: "workflow name is required"},
+		{name: "mismatched name", method: http.MethodPut, target: "/api/workflows/Adult", body: workflow("Other", "pass"), wantErr: "does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := send(tt.method, tt.target, tt.body)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("response = %d %q, want 400 containing %q", rec.Code, rec.Body, tt.wantErr)
+			}
+		})
+	}
+
+	if names, _ := storage.ListWorkflows(context.Background()); !reflect.DeepEqual(names, []string{"adult"}) {
+		t.Errorf("saved workflows = %v, want only Adult", names)
+	}
+	if step := execute(20); step != "minor" {
+		t.Errorf("workflow ended in %q after rejected updates, want minor", step)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// maxMemoryStates is the number of instances MemoryStateStorage keeps; the
+// oldest are dropped first.
+const maxMemoryStates = 10000
+
+// MemoryStateStorage implements StateStorage in memory. State is lost when
+// the process exits, which suits tests and stateless deployments.
+type MemoryStateStorage struct {
+	states map[string][]byte
+	order  []string
+	mu     sync.RWMutex
+}
+
+// NewMemoryStateStorage creates a new in-memory state storage
+func NewMemoryStateStorage() *MemoryStateStorage {
+	return &MemoryStateStorage{
+		states: make(map[string][]byte),
+	}
+}
+
+// SaveState stores a copy of a workflow state by its ID
+func (m *MemoryStateStorage) SaveState(ctx context.Context, workflowName string, state *WorkflowState) error {
+	if state.ID == "" {
+		return fmt.Errorf("state has no id")
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.states[state.ID]; !ok {
+		m.order = append(m.order, state.ID)
+		if len(m.order) > maxMemoryStates {
+			delete(m.states, m.order[0])
+			m.order = m.order[1:]
+		}
+	}
+	m.states[state.ID] = data
+	return nil
+}
+
+// LoadState returns a copy of the stored workflow state
+func (m *MemoryStateStorage) LoadState(ctx context.Context, workflowName string, stateID string) (*WorkflowState, error) {
+	state, err := m.FindState(ctx, stateID)
+	if err != nil {
+		return nil, err
+	}
+	if state.Workflow != workflowName {
+		return nil, fmt.Errorf("%w: %s/%s", ErrStateNotFound, workflowName, stateID)
+	}
+	return state, nil
+}
+
+// FindState returns a copy of the stored workflow state with the given ID
+func (m *MemoryStateStorage) FindState(ctx context.Context, stateID string) (*WorkflowState, error) {
+	m.mu.RLock()
+	data, ok := m.states[stateID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrStateNotFound, stateID)
+	}
+
+	var state WorkflowState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+	return &state, nil
+}
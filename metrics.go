@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics is a registry of counters, gauges and histograms written in the
+// Prometheus text exposition format.
+type Metrics struct {
+	metrics []metric
+	mu      sync.Mutex
+}
+
+type metric interface {
+	name() string
+	write(w io.Writer) error
+}
+
+// NewMetrics creates an empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// register adds a metric, replacing one registered under the same name.
+func (m *Metrics) register(metric metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.metrics {
+		if existing.name() == metric.name() {
+			m.metrics[i] = metric
+			return
+		}
+	}
+	m.metrics = append(m.metrics, metric)
+}
+
+// NewCounter registers a counter with the given label names.
+func (m *Metrics) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: metricDesc{name: name, help: help, labels: labels}, values: make(map[string]*counterValue)}
+	m.register(c)
+	return c
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds, in
+// increasing order, and label names.
+func (m *Metrics) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{desc: metricDesc{name: name, help: help, labels: labels}, buckets: buckets, values: make(map[string]*histogramValue)}
+	m.register(h)
+	return h
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn when the
+// metrics are written.
+func (m *Metrics) NewGaugeFunc(name, help string, fn func() float64) {
+	m.register(&gaugeFunc{desc: metricDesc{name: name, help: help}, fn: fn})
+}
+
+// WriteText writes every metric in the Prometheus text format.
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	metrics := append([]metric(nil), m.metrics...)
+	m.mu.Unlock()
+
+	for _, metric := range metrics {
+		if err := metric.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the metrics for a Prometheus scrape.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteText(w)
+}
+
+type metricDesc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d metricDesc) writeHeader(w io.Writer, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+	return err
+}
+
+// key joins label values into a map key. It panics if the number of values
+// doesn't match the labels, which is a programming error.
+func (d metricDesc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\x00")
+}
+
+// labelPairs formats label values as {a="x",b="y"}, with extra pairs such
+// as le appended.
+func (d metricDesc) labelPairs(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	for i, label := range d.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, labelValueEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], extra[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelValueEscaper escapes label values as the exposition format expects.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	desc   metricDesc
+	values map[string]*counterValue
+	mu     sync.Mutex
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// Inc adds one to the counter for the given label values.
+func (c *CounterVec) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds v, which must not be negative, to the counter for the given
+// label values.
+func (c *CounterVec) Add(v float64, labels ...string) {
+	key := c.desc.key(labels)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.values[key]
+	if !ok {
+		value = &counterValue{labels: append([]string(nil), labels...)}
+		c.values[key] = value
+	}
+	value.value += v
+}
+
+// Value returns the counter for the given label values.
+func (c *CounterVec) Value(labels ...string) float64 {
+	key := c.desc.key(labels)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value, ok := c.values[key]; ok {
+		return value.value
+	}
+	return 0
+}
+
+func (c *CounterVec) name() string {
+	return c.desc.name
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	if err := c.desc.writeHeader(w, "counter"); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		value := c.values[key]
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.desc.name, c.desc.labelPairs(value.labels), formatMetricValue(value.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	desc    metricDesc
+	buckets []float64
+	values  map[string]*histogramValue
+	mu      sync.Mutex
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records a value in the histogram for the given label values.
+func (h *HistogramVec) Observe(v float64, labels ...string) {
+	key := h.desc.key(labels)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{labels: append([]string(nil), labels...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+
+	// Buckets are cumulative when written; count only the first that fits.
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		value.counts[i]++
+	}
+	value.count++
+	value.sum += v
+}
+
+// Count returns the number of values observed for the given label values.
+func (h *HistogramVec) Count(labels ...string) uint64 {
+	key := h.desc.key(labels)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if value, ok := h.values[key]; ok {
+		return value.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) name() string {
+	return h.desc.name
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	if err := h.desc.writeHeader(w, "histogram"); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		value := h.values[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += value.counts[i]
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.desc.name, h.desc.labelPairs(value.labels, "le", formatMetricValue(bound)), cumulative); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.desc.name, h.desc.labelPairs(value.labels, "le", "+Inf"), value.count); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", h.desc.name, h.desc.labelPairs(value.labels), formatMetricValue(value.sum)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_count%s %d\n", h.desc.name, h.desc.labelPairs(value.labels), value.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type gaugeFunc struct {
+	desc metricDesc
+	fn   func() float64
+}
+
+func (g *gaugeFunc) name() string {
+	return g.desc.name
+}
+
+func (g *gaugeFunc) write(w io.Writer) error {
+	if err := g.desc.writeHeader(w, "gauge"); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", g.desc.name, formatMetricValue(g.fn()))
+	return err
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outbox delivery settings. A failed delivery is retried after
+// outboxBackoff, doubling up to outboxMaxBackoff; after outboxMaxAttempts
+// attempts the entry is moved to the failed subdirectory.
+const (
+	outboxWorkers      = 4
+	outboxMaxAttempts  = 20
+	outboxBackoff      = 10 * time.Second
+	outboxMaxBackoff   = time.Hour
+	outboxPollInterval = time.Second
+	outboxFailedDir    = "failed"
+)
+
+// OutboxEntry is a WorkflowEvent waiting to be delivered to an async
+// handler. State is a copy of the instance's state when the event happened.
+type OutboxEntry struct {
+	ID          string         `json:"id"`
+	Handler     string         `json:"handler"`
+	Kind        EventKind      `json:"kind"`
+	Workflow    string         `json:"workflow"`
+	Time        time.Time      `json:"time"`
+	Step        string         `json:"step,omitempty"`
+	FromStep    string         `json:"from,omitempty"`
+	ToStep      string         `json:"to,omitempty"`
+	Rule        string         `json:"rule,omitempty"`
+	RuleResult  *RuleResult    `json:"rule_result,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	State       *WorkflowState `json:"state"`
+	Attempts    int            `json:"attempts"`
+	NextAttempt time.Time      `json:"next_attempt"`
+	LastError   string         `json:"last_error,omitempty"`
+}
+
+// event returns the entry's event. Its error keeps only the message.
+func (entry *OutboxEntry) event() *WorkflowEvent {
+	event := &WorkflowEvent{
+		Kind:       entry.Kind,
+		Workflow:   entry.Workflow,
+		State:      entry.State,
+		Time:       entry.Time,
+		Step:       entry.Step,
+		FromStep:   entry.FromStep,
+		ToStep:     entry.ToStep,
+		Rule:       entry.Rule,
+		RuleResult: entry.RuleResult,
+	}
+	if entry.Error != "" {
+		event.Err = errors.New(entry.Error)
+	}
+	return event
+}
+
+// Outbox delivers events to async handlers in the background. Each event is
+// written to a file in the outbox directory before the workflow carries on
+// and is removed once its handler has returned without error, so events
+// are delivered at least once, even across restarts. Events are delivered
+// concurrently and may arrive out of order; handlers should be idempotent.
+type Outbox struct {
+	dir      string
+	handlers map[string]EventListener
+	waiting  map[string]*OutboxEntry
+	wake     chan struct{}
+	mu       sync.Mutex
+
+	// MaxAttempts, Backoff and MaxBackoff control retries; they can be
+	// changed before Run is called.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// NewOutbox creates an outbox in a directory, loading the events still
+// waiting there from an earlier run.
+func NewOutbox(dir string) (*Outbox, error) {
+	o := &Outbox{
+		dir:         dir,
+		handlers:    make(map[string]EventListener),
+		waiting:     make(map[string]*OutboxEntry),
+		wake:        make(chan struct{}, 1),
+		MaxAttempts: outboxMaxAttempts,
+		Backoff:     outboxBackoff,
+		MaxBackoff:  outboxMaxBackoff,
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox: %w", err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read outbox entry: %w", err)
+		}
+		var entry OutboxEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("invalid outbox entry %s: %w", file, err)
+		}
+		o.waiting[entry.ID] = &entry
+	}
+	return o, nil
+}
+
+// Register adds a listener whose events are delivered by the outbox under
+// an async handler's name. Listeners must be registered before Run so that
+// events left from an earlier run find them.
+func (o *Outbox) Register(name string, listener EventListener) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.handlers[name] = listener
+}
+
+// Pending returns the number of events waiting for delivery.
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.waiting)
+}
+
+// Enqueue stores an event for the listener registered under name, which it
+// registers if it isn't. It returns once the event is safely written to
+// disk.
+func (o *Outbox) Enqueue(name string, listener EventListener, event *WorkflowEvent) error {
+	now := time.Now().UTC()
+	entry := &OutboxEntry{
+		ID:          fmt.Sprintf("%d-%s", now.UnixNano(), newInstanceID()),
+		Handler:     name,
+		Kind:        event.Kind,
+		Workflow:    event.Workflow,
+		Time:        event.Time,
+		Step:        event.Step,
+		FromStep:    event.FromStep,
+		ToStep:      event.ToStep,
+		Rule:        event.Rule,
+		RuleResult:  event.RuleResult,
+		State:       event.State.snapshot(),
+		NextAttempt: now,
+	}
+	if event.Err != nil {
+		entry.Error = event.Err.Error()
+	}
+	if err := o.write(entry); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.handlers[name] = listener
+	o.waiting[entry.ID] = entry
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers events with the given number of workers until the context is
+// done, then waits for the deliveries in progress. Their handlers see the
+// context cancelled; events that fail that way are delivered again on the
+// next run without counting the attempt.
+func (o *Outbox) Run(ctx context.Context, workers int) {
+	if workers <= 0 {
+		workers = outboxWorkers
+	}
+
+	o.mu.Lock()
+	for _, entry := range o.waiting {
+		if _, ok := o.handlers[entry.Handler]; !ok {
+			slog.Warn("outbox event for unknown handler is not delivered", "handler", entry.Handler, "event_id", entry.ID)
+		}
+	}
+	o.mu.Unlock()
+
+	ready := make(chan *OutboxEntry)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range ready {
+				o.deliver(ctx, entry)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(ready)
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		due := o.due()
+		for i, entry := range due {
+			select {
+			case ready <- entry:
+			case <-ctx.Done():
+				o.mu.Lock()
+				for _, entry := range due[i:] {
+					o.waiting[entry.ID] = entry
+				}
+				o.mu.Unlock()
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// due takes the entries whose next attempt is due, oldest first, off the
+// waiting list.
+func (o *Outbox) due() []*OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	var due []*OutboxEntry
+	for id, entry := range o.waiting {
+		if _, ok := o.handlers[entry.Handler]; ok && !entry.NextAttempt.After(now) {
+			due = append(due, entry)
+			delete(o.waiting, id)
+		}
+	}
+	slices.SortFunc(due, func(a, b *OutboxEntry) int { return strings.Compare(a.ID, b.ID) })
+	return due
+}
+
+// deliver makes one attempt to deliver an entry. A delivered entry is
+// removed; a failed one is rescheduled, or moved to the failed directory
+// when it has run out of attempts.
+func (o *Outbox) deliver(ctx context.Context, entry *OutboxEntry) {
+	o.mu.Lock()
+	listener := o.handlers[entry.Handler]
+	if ctx.Err() != nil {
+		// Run may hand out an entry as it shuts down; keep it for the next run.
+		o.waiting[entry.ID] = entry
+		o.mu.Unlock()
+		return
+	}
+	o.mu.Unlock()
+
+	logger := slog.Default().With("handler", entry.Handler, "event", entry.Kind, "event_id", entry.ID,
+		"workflow", entry.Workflow, "instance_id", entry.State.ID)
+	err := listener.OnEvent(contextWithLogger(ctx, logger), entry.event())
+	if err == nil {
+		if err := os.Remove(o.path(entry)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Error("failed to remove delivered outbox event", "error", err)
+		}
+		return
+	}
+
+	// A delivery interrupted by the shutdown isn't the handler's failure:
+	// the entry is delivered again, as it was, on the next run.
+	if ctx.Err() != nil {
+		logger.Info("outbox event delivery interrupted by shutdown", "error", err)
+		o.mu.Lock()
+		o.waiting[entry.ID] = entry
+		o.mu.Unlock()
+		return
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+	if entry.Attempts >= o.MaxAttempts {
+		logger.Error("outbox event delivery failed, giving up", "attempts", entry.Attempts, "error", err)
+		if err := o.moveToFailed(entry); err != nil {
+			logger.Error("failed to move outbox event", "error", err)
+		}
+		return
+	}
+
+	backoff := o.Backoff
+	for i := 1; i < entry.Attempts && backoff < o.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	entry.NextAttempt = time.Now().UTC().Add(min(backoff, o.MaxBackoff))
+	logger.Warn("outbox event delivery failed", "attempts", entry.Attempts, "next_attempt", entry.NextAttempt, "error", err)
+	if err := o.write(entry); err != nil {
+		logger.Error("failed to update outbox event", "error", err)
+	}
+
+	o.mu.Lock()
+	o.waiting[entry.ID] = entry
+	o.mu.Unlock()
+}
+
+func (o *Outbox) path(entry *OutboxEntry) string {
+	return filepath.Join(o.dir, entry.ID+".json")
+}
+
+// write stores an entry, replacing the file atomically and syncing it so
+// that a crash never loses or truncates it.
+func (o *Outbox) write(entry *OutboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox event: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(o.dir, entry.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write outbox event: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), o.path(entry))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write outbox event: %w", err)
+	}
+	return nil
+}
+
+// moveToFailed keeps an entry that ran out of attempts in the failed
+// subdirectory, where it can be inspected and moved back to be retried.
+func (o *Outbox) moveToFailed(entry *OutboxEntry) error {
+	if err := o.write(entry); err != nil {
+		return err
+	}
+	failedDir := filepath.Join(o.dir, outboxFailedDir)
+	if err := os.MkdirAll(failedDir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(o.path(entry), filepath.Join(failedDir, entry.ID+".json"))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingListener fails its first failures deliveries and records the
+// events it accepts.
+type countingListener struct {
+	failures int
+
+	mu       sync.Mutex
+	attempts int
+	events   []*WorkflowEvent
+}
+
+func (l *countingListener) OnEvent(ctx context.Context, event *WorkflowEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attempts++
+	if l.attempts <= l.failures {
+		return errors.New("subscriber unavailable")
+	}
+	l.events = append(l.events, event)
+	return nil
+}
+
+func (l *countingListener) counts() (attempts, delivered int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.attempts, len(l.events)
+}
+
+func testOutboxEvent() *WorkflowEvent {
+	return &WorkflowEvent{
+		Kind:     EventStepTransition,
+		Workflow: "Onboarding",
+		Time:     time.Now(),
+		FromStep: "start",
+		ToStep:   "end",
+		State:    &WorkflowState{ID: "instance-1", CurrentStep: "end", Data: map[string]any{"name": "Sam"}},
+	}
+}
+
+// runOutbox runs an outbox until the test ends or the returned function
+// stops it.
+func runOutbox(t *testing.T, o *Outbox) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx, 2)
+		close(done)
+	}()
+	stop = func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func outboxFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func readOutboxEntry(t *testing.T, path string) OutboxEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry OutboxEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func TestOutboxReloadAndDeliver(t *testing.T) {
+	dir := t.TempDir()
+	first, err := NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Enqueue("test", &countingListener{}, testOutboxEvent()); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	// The entry survives a restart before it was delivered.
+	reloaded, err := NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Pending() != 1 {
+		t.Fatalf("reloaded outbox has %d pending events, want 1", reloaded.Pending())
+	}
+
+	listener := &countingListener{}
+	reloaded.Register("test", listener)
+	runOutbox(t, reloaded)
+	waitFor(t, "delivery", func() bool { _, delivered := listener.counts(); return delivered == 1 })
+
+	event := listener.events[0]
+	if event.Kind != EventStepTransition || event.FromStep != "start" || event.ToStep != "end" ||
+		event.State.ID != "instance-1" || event.State.Data["name"] != "Sam" {
+		t.Errorf("delivered event = %+v", event)
+	}
+	waitFor(t, "removal of the delivered entry", func() bool { return len(outboxFiles(t, dir)) == 0 })
+	if reloaded.Pending() != 0 {
+		t.Errorf("%d events still pending", reloaded.Pending())
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	dir := t.TempDir()
+	o, err := NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Backoff = time.Hour
+	listener := &countingListener{failures: 1}
+	o.Register("test", listener)
+	runOutbox(t, o)
+
+	before := time.Now()
+	if err := o.Enqueue("test", listener, testOutboxEvent()); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitFor(t, "a failed attempt", func() bool {
+		files := outboxFiles(t, dir)
+		return len(files) == 1 && readOutboxEntry(t, files[0]).Attempts == 1
+	})
+
+	entry := readOutboxEntry(t, outboxFiles(t, dir)[0])
+	if entry.LastError != "subscriber unavailable" {
+		t.Errorf("last error = %q", entry.LastError)
+	}
+	if wait := entry.NextAttempt.Sub(before); wait < time.Hour || wait > time.Hour+time.Minute {
+		t.Errorf("next attempt in %v, want an hour", wait)
+	}
+	if attempts, _ := listener.counts(); attempts != 1 {
+		t.Errorf("got %d attempts before the backoff ended, want 1", attempts)
+	}
+}
+
+func TestOutboxGivesUp(t *testing.T) {
+	dir := t.TempDir()
+	o, err := NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.MaxAttempts = 2
+	o.Backoff = time.Millisecond
+	listener := &countingListener{failures: 100}
+	o.Register("test", listener)
+	runOutbox(t, o)
+
+	if err := o.Enqueue("test", listener, testOutboxEvent()); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	failedDir := filepath.Join(dir, outboxFailedDir)
+	waitFor(t, "the entry to move to failed/", func() bool { return len(outboxFiles(t, failedDir)) == 1 })
+
+	if files := outboxFiles(t, dir); len(files) != 0 {
+		t.Errorf("outbox still has %d entries", len(files))
+	}
+	entry := readOutboxEntry(t, outboxFiles(t, failedDir)[0])
+	if entry.Attempts != 2 || entry.LastError == "" {
+		t.Errorf("failed entry has %d attempts and last error %q", entry.Attempts, entry.LastError)
+	}
+	if attempts, _ := listener.counts(); attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+}
+
+func TestOutboxShutdownIsNotAnAttempt(t *testing.T) {
+	dir := t.TempDir()
+	o, err := NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	blocking := EventListenerFunc(func(ctx context.Context, event *WorkflowEvent) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	o.Register("test", blocking)
+	stop := runOutbox(t, o)
+
+	if err := o.Enqueue("test", blocking, testOutboxEvent()); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	<-started
+	stop()
+
+	files := outboxFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("outbox has %d entries, want 1", len(files))
+	}
+	if entry := readOutboxEntry(t, files[0]); entry.Attempts != 0 || entry.LastError != "" {
+		t.Errorf("interrupted entry has %d attempts and last error %q, want none", entry.Attempts, entry.LastError)
+	}
+	if o.Pending() != 1 {
+		t.Errorf("%d events pending, want 1", o.Pending())
+	}
+}
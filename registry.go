@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// RuleFunc is a Go function that can be evaluated as a rule.
+type RuleFunc func(ctx context.Context, data map[string]any) (bool, error)
+
+// ActionFunc is a Go function that can be run as a step action.
+type ActionFunc func(ctx context.Context, state *WorkflowState) error
+
+// Registry holds Go-native rules and actions that workflows can refer to by
+// name, alongside the scripts in the rules directory.
+type Registry struct {
+	rules   map[string]RuleFunc
+	actions map[string]ActionFunc
+	mu      sync.RWMutex
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		rules:   make(map[string]RuleFunc),
+		actions: make(map[string]ActionFunc),
+	}
+}
+
+// RegisterRule registers a Go rule, replacing any rule of the same name
+func (r *Registry) RegisterRule(name string, fn RuleFunc) error {
+	if name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	if fn == nil {
+		return fmt.Errorf("rule '%s' has no function", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules[name] = fn
+	return nil
+}
+
+// RegisterAction registers a Go action, replacing any action of the same name
+func (r *Registry) RegisterAction(name string, fn ActionFunc) error {
+	if name == "" {
+		return fmt.Errorf("action name is required")
+	}
+	if fn == nil {
+		return fmt.Errorf("action '%s' has no function", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions[name] = fn
+	return nil
+}
+
+// Rule looks up a registered rule
+func (r *Registry) Rule(name string) (RuleFunc, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fn, ok := r.rules[name]
+	return fn, ok
+}
+
+// Action looks up a registered action
+func (r *Registry) Action(name string) (ActionFunc, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fn, ok := r.actions[name]
+	return fn, ok
+}
+
+// RuleNames returns the names of all registered rules, sorted
+func (r *Registry) RuleNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return sortedKeys(r.rules)
+}
+
+// ActionNames returns the names of all registered actions, sorted
+func (r *Registry) ActionNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return sortedKeys(r.actions)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// toRuleFunc converts the values accepted by RuleEngine.RegisterRule into a
+// RuleFunc.
+func toRuleFunc(rule any) (RuleFunc, error) {
+	switch fn := rule.(type) {
+	case RuleFunc:
+		return fn, nil
+	case func(context.Context, map[string]any) (bool, error):
+		return fn, nil
+	default:
+		return nil, fmt.Errorf("unsupported rule type %T", rule)
+	}
+}
+
+// GoRuleEngine implements the RuleEngine interface for the Go rules in a
+// registry.
+type GoRuleEngine struct {
+	registry *Registry
+}
+
+// NewGoRuleEngine creates a rule engine over a registry
+func NewGoRuleEngine(registry *Registry) *GoRuleEngine {
+	return &GoRuleEngine{registry: registry}
+}
+
+// Evaluate calls the registered Go rule.
+func (g *GoRuleEngine) Evaluate(ctx context.Context, ruleName string, data map[string]any) (bool, error) {
+	fn, ok := g.registry.Rule(ruleName)
+	if !ok {
+		return false, fmt.Errorf("go rule '%s' is not registered", ruleName)
+	}
+	return fn(ctx, data)
+}
+
+// EvaluateMutating calls the registered Go rule on a deep copy of the data.
+func (g *GoRuleEngine) EvaluateMutating(ctx context.Context, ruleName string, data map[string]any) (bool, map[string]any, error) {
+	fn, ok := g.registry.Rule(ruleName)
+	if !ok {
+		return false, nil, fmt.Errorf("go rule '%s' is not registered", ruleName)
+	}
+	return evaluateRuleFunc(ctx, fn, data, true)
+}
+
+// RegisterRule registers a Go rule in the registry.
+func (g *GoRuleEngine) RegisterRule(name string, rule any) error {
+	fn, err := toRuleFunc(rule)
+	if err != nil {
+		return err
+	}
+	return g.registry.RegisterRule(name, fn)
+}
+
+// Language returns the rule language
+func (g *GoRuleEngine) Language() string {
+	return LanguageGo
+}
+
+// Extensions returns the rule file extensions; Go rules have no files
+func (g *GoRuleEngine) Extensions() []string {
+	return nil
+}
+
+// HasRule reports whether the rule is registered
+func (g *GoRuleEngine) HasRule(name string) bool {
+	_, ok := g.registry.Rule(name)
+	return ok
+}
+
+// evaluateRuleFunc calls a Go rule. Mutating rules work on a deep copy of the
+// data, so that changes to nested maps and lists also reach the caller only
+// through the returned data.
+func evaluateRuleFunc(ctx context.Context, fn RuleFunc, data map[string]any, mutate bool) (bool, map[string]any, error) {
+	if !mutate {
+		result, err := fn(ctx, data)
+		return result, nil, err
+	}
+
+	updated := CopyData(data)
+	if updated == nil {
+		updated = make(map[string]any)
+	}
+	result, err := fn(ctx, updated)
+	if err != nil {
+		return false, nil, err
+	}
+	return result, updated, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGoRuleEvaluateMutating(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterRule("ship", func(ctx context.Context, data map[string]any) (bool, error) {
+		data["address"].(map[string]any)["city"] = "Bergen"
+		data["tags"].([]string)[0] = "shipped"
+		delete(data, "draft")
+		return true, nil
+	})
+	engine := NewGoRuleEngine(registry)
+
+	data := map[string]any{
+		"address": map[string]any{"city": "Oslo"},
+		"tags":    []string{"new"},
+		"draft":   true,
+	}
+	_, updated, err := engine.EvaluateMutating(context.Background(), "ship", data)
+	if err != nil {
+		t.Fatalf("EvaluateMutating: %v", err)
+	}
+
+	want := map[string]any{
+		"address": map[string]any{"city": "Oslo"},
+		"tags":    []string{"new"},
+		"draft":   true,
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("rule changed its input to %#v", data)
+	}
+	wantDiff := DataDiff{
+		"address": {Old: map[string]any{"city": "Oslo"}, New: map[string]any{"city": "Bergen"}},
+		"tags":    {Old: []string{"new"}, New: []string{"shipped"}},
+		"draft":   {Old: true, Removed: true},
+	}
+	if diff := DiffData(data, updated); !reflect.DeepEqual(diff, wantDiff) {
+		t.Errorf("diff = %#v, want %#v", diff, wantDiff)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleSpec is the rule guarding a transition. It is either the name of a
+// rule (or an inline expression), written as a plain string, or a boolean
+// combination of other specs:
+//
+//	rule:
+//	  all_of:
+//	    - is_over_18
+//	    - not: is_blocked
+//	    - any_of: [is_premium_customer, "referrals >= 3"]
+type RuleSpec struct {
+	Name  string
+	AllOf []RuleSpec
+	AnyOf []RuleSpec
+	Not   *RuleSpec
+}
+
+// ruleSpecFields is the mapping form of a RuleSpec.
+type ruleSpecFields struct {
+	AllOf []RuleSpec `json:"all_of,omitempty" yaml:"all_of,omitempty"`
+	AnyOf []RuleSpec `json:"any_of,omitempty" yaml:"any_of,omitempty"`
+	Not   *RuleSpec  `json:"not,omitempty" yaml:"not,omitempty"`
+}
+
+// String returns a compact description such as "all_of(a, not(b))".
+func (r RuleSpec) String() string {
+	switch {
+	case r.Not != nil:
+		return fmt.Sprintf("not(%s)", r.Not)
+	case r.AllOf != nil:
+		return fmt.Sprintf("all_of(%s)", joinRuleSpecs(r.AllOf))
+	case r.AnyOf != nil:
+		return fmt.Sprintf("any_of(%s)", joinRuleSpecs(r.AnyOf))
+	default:
+		return r.Name
+	}
+}
+
+func joinRuleSpecs(specs []RuleSpec) string {
+	parts := make([]string, len(specs))
+	for i, spec := range specs {
+		parts[i] = spec.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Leaves returns the names of every rule the spec refers to.
+func (r RuleSpec) Leaves() []string {
+	switch {
+	case r.Not != nil:
+		return r.Not.Leaves()
+	case r.AllOf != nil || r.AnyOf != nil:
+		var names []string
+		for _, spec := range append(append([]RuleSpec{}, r.AllOf...), r.AnyOf...) {
+			names = append(names, spec.Leaves()...)
+		}
+		return names
+	default:
+		return []string{r.Name}
+	}
+}
+
+// Validate checks that the spec and all nested specs are well formed.
+func (r RuleSpec) Validate() error {
+	set := 0
+	for _, present := range []bool{r.Name != "", r.AllOf != nil, r.AnyOf != nil, r.Not != nil} {
+		if present {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("rule must be a rule name or exactly one of all_of, any_of or not")
+	}
+
+	switch {
+	case r.Not != nil:
+		return r.Not.Validate()
+	case r.AllOf != nil || r.AnyOf != nil:
+		specs := r.AllOf
+		if r.AnyOf != nil {
+			specs = r.AnyOf
+		}
+		if len(specs) == 0 {
+			return fmt.Errorf("%s must list at least one rule", r)
+		}
+		for _, spec := range specs {
+			if err := spec.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalYAML accepts either a rule name or a combination mapping.
+func (r *RuleSpec) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*r = RuleSpec{Name: node.Value}
+		return nil
+	}
+
+	var fields ruleSpecFields
+	if err := node.Decode(&fields); err != nil {
+		return err
+	}
+	*r = RuleSpec{AllOf: fields.AllOf, AnyOf: fields.AnyOf, Not: fields.Not}
+	return nil
+}
+
+// MarshalYAML writes named rules as plain strings.
+func (r RuleSpec) MarshalYAML() (any, error) {
+	if r.Name != "" {
+		return r.Name, nil
+	}
+	return ruleSpecFields{AllOf: r.AllOf, AnyOf: r.AnyOf, Not: r.Not}, nil
+}
+
+// UnmarshalJSON accepts either a rule name or a combination object.
+func (r *RuleSpec) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*r = RuleSpec{Name: name}
+		return nil
+	}
+
+	var fields ruleSpecFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*r = RuleSpec{AllOf: fields.AllOf, AnyOf: fields.AnyOf, Not: fields.Not}
+	return nil
+}
+
+// MarshalJSON writes named rules as plain strings.
+func (r RuleSpec) MarshalJSON() ([]byte, error) {
+	if r.Name != "" {
+		return json.Marshal(r.Name)
+	}
+	return json.Marshal(ruleSpecFields{AllOf: r.AllOf, AnyOf: r.AnyOf, Not: r.Not})
+}
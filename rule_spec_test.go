@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRuleSpecEvaluation(t *testing.T) {
+	var calls []string
+	registry := NewRegistry()
+	for name, result := range map[string]bool{"yes": true, "no": false} {
+		registry.RegisterRule(name, func(ctx context.Context, data map[string]any) (bool, error) {
+			calls = append(calls, name)
+			return result, nil
+		})
+	}
+	registry.RegisterRule("broken", func(ctx context.Context, data map[string]any) (bool, error) {
+		calls = append(calls, "broken")
+		return false, errors.New("broken rule")
+	})
+
+	tests := []struct {
+		name      string
+		rule      string
+		wantStep  string
+		wantTrace RuleResult
+		wantCalls []string
+		wantErr   string
+	}{
+		{
+			name:     "all_of stops at the first failing rule",
+			rule:     "{all_of: [yes, no, broken]}",
+			wantStep: "rejected",
+			wantTrace: RuleResult{Rule: "all_of", Result: false, Children: []RuleResult{
+				{Rule: "yes", Result: true},
+				{Rule: "no", Result: false},
+			}},
+			wantCalls: []string{"yes", "no"},
+		},
+		{
+			name:     "all_of passes when every rule passes",
+			rule:     `{all_of: [yes, "age >= 18"]}`,
+			wantStep: "approved",
+			wantTrace: RuleResult{Rule: "all_of", Result: true, Children: []RuleResult{
+				{Rule: "yes", Result: true},
+				{Rule: "age >= 18", Result: true},
+			}},
+			wantCalls: []string{"yes"},
+		},
+		{
+			name:     "any_of stops at the first passing rule",
+			rule:     "{any_of: [no, yes, broken]}",
+			wantStep: "approved",
+			wantTrace: RuleResult{Rule: "any_of", Result: true, Children: []RuleResult{
+				{Rule: "no", Result: false},
+				{Rule: "yes", Result: true},
+			}},
+			wantCalls: []string{"no", "yes"},
+		},
+		{
+			name:     "any_of fails when no rule passes",
+			rule:     `{any_of: [no, "age < 18"]}`,
+			wantStep: "rejected",
+			wantTrace: RuleResult{Rule: "any_of", Result: false, Children: []RuleResult{
+				{Rule: "no", Result: false},
+				{Rule: "age < 18", Result: false},
+			}},
+			wantCalls: []string{"no"},
+		},
+		{
+			name:     "not",
+			rule:     "{not: no}",
+			wantStep: "approved",
+			wantTrace: RuleResult{Rule: "not", Result: true, Children: []RuleResult{
+				{Rule: "no", Result: false},
+			}},
+			wantCalls: []string{"no"},
+		},
+		{
+			name:     "nested",
+			rule:     "{all_of: [{not: no}, {any_of: [no, {not: yes}]}]}",
+			wantStep: "rejected",
+			wantTrace: RuleResult{Rule: "all_of", Result: false, Children: []RuleResult{
+				{Rule: "not", Result: true, Children: []RuleResult{{Rule: "no", Result: false}}},
+				{Rule: "any_of", Result: false, Children: []RuleResult{
+					{Rule: "no", Result: false},
+					{Rule: "not", Result: false, Children: []RuleResult{{Rule: "yes", Result: true}}},
+				}},
+			}},
+			wantCalls: []string{"no", "no", "yes"},
+		},
+		{
+			name:      "error",
+			rule:      "{any_of: [no, {not: broken}, yes]}",
+			wantCalls: []string{"no", "broken"},
+			wantErr:   "failed to evaluate rule 'any_of(no, not(broken), yes)': broken rule",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workflow := `
+name: Review
+start_step: start
+transitions:
+  - from: start
+    to: approved
+    fallback_to: rejected
+    rule: ` + tt.rule + "\n"
+			engine, err := newTestEngine(t, EngineOptions{Registry: registry}, map[string]string{"review.yml": workflow}, nil)
+			if err != nil {
+				t.Fatalf("NewWorkflowEngine: %v", err)
+			}
+
+			calls = nil
+			state := &WorkflowState{Data: map[string]any{"age": 30}}
+			err = engine.RunWorkflow(context.Background(), "Review", state)
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("evaluated %v, want %v", calls, tt.wantCalls)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RunWorkflow: %v", err)
+			}
+			if state.CurrentStep != tt.wantStep {
+				t.Errorf("instance ended in %q, want %q", state.CurrentStep, tt.wantStep)
+			}
+			if trace := state.LastTransition.RuleTrace; trace == nil || !reflect.DeepEqual(*trace, tt.wantTrace) {
+				t.Errorf("rule trace = %+v, want %+v", trace, tt.wantTrace)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+-- This is a step action: the engine calls 'run' when an instance enters a
+-- step that lists it under on_enter or on_exit.
+function run(data, step)
+	-- Returned keys are merged into the workflow data.
+	if data.customer_type == "premium" then
+		return { welcome_offer = "premium_bundle" }
+	end
+	return { welcome_offer = "standard_bundle" }
+end
@@ -0,0 +1,6 @@
+-- Uses a shared module from rules/lib and the 'wf' host library.
+local validation = require("validation")
+
+function check(data)
+	return validation.is_email(wf.lookup(data, "email"))
+end
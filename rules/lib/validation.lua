@@ -0,0 +1,11 @@
+-- Shared helpers for rules. Load with: local validation = require("validation")
+local validation = {}
+
+-- Patterns use Go regular expression syntax.
+local email_pattern = "^[^@\\s]+@[^@\\s]+\\.[^@\\s]+$"
+
+function validation.is_email(value)
+	return type(value) == "string" and wf.regex_match(email_pattern, value)
+end
+
+return validation
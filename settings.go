@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidSettings is returned when a settings change has an unknown key
+// or an unusable value.
+var ErrInvalidSettings = errors.New("invalid settings")
+
+// maskedSecret stands in for secret settings returned by the settings API.
+// Sending it back leaves the secret unchanged.
+const maskedSecret = "********"
+
+// secretConfigKeys are settings never returned by the settings API.
+var secretConfigKeys = map[string]bool{
+	"auth_token": true,
+}
+
+// editableConfigKeys are the settings the settings API may change: those on
+// the Settings page. Paths, addresses and secrets can only be changed in
+// the configuration file.
+var editableConfigKeys = map[string]bool{
+	"workflow_timeout_seconds":  true,
+	"max_concurrent_executions": true,
+	"lua_pool_size":             true,
+	"rule_cache_ttl_seconds":    true,
+	"rule_versioning":           true,
+}
+
+// Settings is the configuration of a running server. Changes are written
+// to the configuration file and the hot settings are applied straight away;
+// the others take effect at the next restart.
+type Settings struct {
+	path    string
+	file    *Config
+	current *Config
+	started *Config
+	apply   func(cfg *Config)
+	mu      sync.Mutex
+}
+
+// SettingsUpdate reports the outcome of a settings change: the keys that
+// changed, those applied to the running server, and every setting changed
+// since startup that only takes effect after a restart.
+type SettingsUpdate struct {
+	Changed         []string `json:"changed"`
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// NewSettings creates the settings of a server started with current, which
+// is file with environment and flag overrides applied. Changes are saved to
+// the file at path and passed to apply.
+func NewSettings(path string, file, current *Config, apply func(cfg *Config)) *Settings {
+	started := *current
+	return &Settings{path: path, file: file, current: current, started: &started, apply: apply}
+}
+
+// Config returns a copy of the current configuration.
+func (s *Settings) Config() Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.current
+}
+
+// RestartRequired returns the settings changed since startup that only
+// take effect after a restart.
+func (s *Settings) RestartRequired() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restartRequired()
+}
+
+func (s *Settings) restartRequired() []string {
+	keys := []string{}
+	for _, key := range s.started.Diff(s.current) {
+		if !IsHotConfigKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Update validates and applies new values for the given keys, which must
+// be editable settings. Nothing is changed if any key is not editable or any
+// value is invalid, in which case the error wraps ErrInvalidSettings.
+func (s *Settings) Update(values map[string]string) (SettingsUpdate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next, file := *s.current, *s.file
+	for key, value := range values {
+		if !editableConfigKeys[key] {
+			return SettingsUpdate{}, fmt.Errorf("%w: %s cannot be changed through the settings API", ErrInvalidSettings, key)
+		}
+		if err := next.Set(key, value); err != nil {
+			return SettingsUpdate{}, fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+		}
+		file.Set(key, value)
+	}
+	if problems := next.problems(); len(problems) > 0 {
+		return SettingsUpdate{}, fmt.Errorf("%w: %s", ErrInvalidSettings, strings.Join(problems, "; "))
+	}
+
+	if saved := file.Diff(s.file); len(saved) > 0 {
+		if err := file.SaveKeys(s.path, saved); err != nil {
+			return SettingsUpdate{}, fmt.Errorf("failed to save settings: %w", err)
+		}
+	}
+
+	update := SettingsUpdate{Changed: s.current.Diff(&next), Applied: []string{}}
+	*s.current, *s.file = next, file
+	if len(update.Changed) > 0 {
+		s.apply(s.current)
+	}
+
+	for _, key := range update.Changed {
+		if IsHotConfigKey(key) {
+			update.Applied = append(update.Applied, key)
+		}
+	}
+	update.RestartRequired = s.restartRequired()
+	return update, nil
+}
+
+// settingsJSON returns a configuration as a JSON object keyed by setting,
+// with secrets masked.
+func settingsJSON(cfg Config) map[string]any {
+	settings := make(map[string]any)
+	for _, key := range ConfigKeys() {
+		field, _ := cfg.field(key)
+		settings[key] = field.Interface()
+		if secretConfigKeys[key] && field.String() != "" {
+			settings[key] = maskedSecret
+		}
+	}
+	return settings
+}
+
+// parseSettingsJSON converts a JSON object of settings to the string values
+// Config.Set parses. Secrets sent back masked are left out.
+func parseSettingsJSON(data []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		var text string
+		switch {
+		case bytes.HasPrefix(value, []byte(`"`)):
+			json.Unmarshal(value, &text)
+		case bytes.HasPrefix(value, []byte("{")), bytes.HasPrefix(value, []byte("[")), bytes.Equal(value, []byte("null")):
+			return nil, fmt.Errorf("%s: expected a string, number or boolean", key)
+		default:
+			text = string(value)
+		}
+
+		if secretConfigKeys[key] && text == maskedSecret {
+			continue
+		}
+		values[key] = text
+	}
+	return values, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WorkflowTestFile is a set of test cases for one workflow, stored as a
+// .test.yaml file:
+//
+//	workflow: CustomerOnboarding
+//	cases:
+//	  - name: adult premium customer
+//	    data: {name: Alice, age: 30, customer_type: premium}
+//	    expect:
+//	      status: completed
+//	      path: [start, is_over_18_check, is_premium_customer_check, premium_onboarding, end]
+//	      data: {welcome_offer: premium_bundle}
+type WorkflowTestFile struct {
+	Workflow string             `yaml:"workflow"`
+	Cases    []WorkflowTestCase `yaml:"cases"`
+}
+
+// WorkflowTestCase runs a workflow with the given data and checks the
+// outcome. Only the expectations that are set are checked.
+type WorkflowTestCase struct {
+	Name   string           `yaml:"name"`
+	Data   map[string]any   `yaml:"data"`
+	Expect WorkflowExpected `yaml:"expect"`
+}
+
+// WorkflowExpected is the expected outcome of a test case. Data lists fields
+// the final data must contain with the given values; Error is a substring
+// of the expected error.
+type WorkflowExpected struct {
+	Status    string         `yaml:"status,omitempty"`
+	FinalStep string         `yaml:"final_step,omitempty"`
+	Path      []string       `yaml:"path,omitempty"`
+	Data      map[string]any `yaml:"data,omitempty"`
+	Error     string         `yaml:"error,omitempty"`
+}
+
+// WorkflowTestResult is the outcome of one test case.
+type WorkflowTestResult struct {
+	File     string
+	Workflow string
+	Case     string
+	Failures []string
+}
+
+// Passed reports whether the case met all its expectations.
+func (r WorkflowTestResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// LoadWorkflowTestFile reads a test case file.
+func LoadWorkflowTestFile(path string) (*WorkflowTestFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file WorkflowTestFile
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid test file %s: %w", path, err)
+	}
+	if file.Workflow == "" {
+		return nil, fmt.Errorf("invalid test file %s: workflow is required", path)
+	}
+	return &file, nil
+}
+
+// FindWorkflowTestFiles returns the .test.yaml and .test.yml files in the
+// given paths, searching directories recursively.
+func FindWorkflowTestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && (strings.HasSuffix(p, ".test.yaml") || strings.HasSuffix(p, ".test.yml")) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// RunWorkflowTests runs every case in a test file. Event handlers are not
+// notified of test runs.
+func (e *WorkflowEngine) RunWorkflowTests(ctx context.Context, path string, file *WorkflowTestFile) []WorkflowTestResult {
+	wf, ok := e.Workflow(file.Workflow)
+
+	results := make([]WorkflowTestResult, 0, len(file.Cases))
+	for i, tc := range file.Cases {
+		name := tc.Name
+		if name == "" {
+			name = fmt.Sprintf("case %d", i+1)
+		}
+		result := WorkflowTestResult{File: path, Workflow: file.Workflow, Case: name}
+
+		if !ok {
+			result.Failures = []string{fmt.Sprintf("workflow '%s' not found", file.Workflow)}
+		} else {
+			state := &WorkflowState{Data: CopyData(tc.Data)}
+			err := e.runWorkflow(ctx, wf, state, nil)
+			result.Failures = tc.Expect.check(state, err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (x WorkflowExpected) check(state *WorkflowState, runErr error) []string {
+	var failures []string
+
+	errText := state.Error
+	if errText == "" && runErr != nil {
+		errText = runErr.Error()
+	}
+	status := state.Status
+	if runErr != nil {
+		status = StatusFailed
+	}
+
+	if x.Error == "" && runErr != nil && x.Status != StatusFailed {
+		failures = append(failures, fmt.Sprintf("unexpected error: %s", errText))
+	}
+	if x.Error != "" && !strings.Contains(errText, x.Error) {
+		failures = append(failures, fmt.Sprintf("error: expected to contain %q, got %q", x.Error, errText))
+	}
+	if x.Status != "" && status != x.Status {
+		failures = append(failures, fmt.Sprintf("status: expected %q, got %q", x.Status, status))
+	}
+	if x.FinalStep != "" && state.CurrentStep != x.FinalStep {
+		failures = append(failures, fmt.Sprintf("final_step: expected %q, got %q", x.FinalStep, state.CurrentStep))
+	}
+	if x.Path != nil && strings.Join(x.Path, " > ") != strings.Join(state.Path, " > ") {
+		failures = append(failures, fmt.Sprintf("path: expected %s, got %s", strings.Join(x.Path, " > "), strings.Join(state.Path, " > ")))
+	}
+	for _, key := range sortedKeys(x.Data) {
+		actual, exists := state.Data[key]
+		if !exists {
+			failures = append(failures, fmt.Sprintf("data.%s: expected %v, but it is not set", key, x.Data[key]))
+		} else if !valuesEqual(actual, x.Data[key]) {
+			failures = append(failures, fmt.Sprintf("data.%s: expected %v, got %v", key, x.Data[key], actual))
+		}
+	}
+	return failures
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID and SpanID identify traces and spans as in W3C Trace Context.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the part of a span that is propagated to its children,
+// within the process or across services in a traceparent header.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value, as in
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent '%s'", value)
+	}
+	// Version 00 has exactly four fields; later versions may add more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent '%s'", value)
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	_, err1 := hex.Decode(sc.TraceID[:], []byte(parts[1]))
+	_, err2 := hex.Decode(sc.SpanID[:], []byte(parts[2]))
+	_, err3 := hex.Decode(flags[:], []byte(parts[3]))
+	if err1 != nil || err2 != nil || err3 != nil || !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent '%s'", value)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// Span kinds, numbered as in OTLP.
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+)
+
+// Span is a timed operation within a trace. A nil *Span, returned when no
+// tracer is set, records nothing.
+type Span struct {
+	Name         string
+	Kind         int
+	Context      SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]any
+	// Error holds the message of the error that failed the operation.
+	Error string
+
+	tracer *Tracer
+	ended  bool
+	mu     sync.Mutex
+}
+
+// SetAttributes sets attributes given as key-value pairs; values are
+// strings, bools, ints or float64s.
+func (s *Span) SetAttributes(keyValues ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		if key, ok := keyValues[i].(string); ok {
+			s.Attributes[key] = keyValues[i+1]
+		}
+	}
+}
+
+// RecordError marks the span as failed by err, if it isn't nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// Finish ends the span and hands it to the tracer for export. Only the
+// first call has an effect.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a context whose spans are children of sc,
+// such as a span context received from another service.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span, if
+// there is one.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// tracerQueueSize is the number of finished spans waiting for export;
+// spans finished while the queue is full are dropped. Spans are exported
+// in batches of up to tracerBatchSize, at least every tracerFlushInterval.
+const (
+	tracerQueueSize     = 4096
+	tracerBatchSize     = 512
+	tracerFlushInterval = 5 * time.Second
+)
+
+// Tracer creates spans and exports them in batches in the background. A nil
+// *Tracer creates no spans.
+type Tracer struct {
+	exporter SpanExporter
+	queue    chan *Span
+	done     chan struct{}
+	closed   bool
+	mu       sync.Mutex
+}
+
+// NewTracer creates a tracer exporting spans to the given exporter.
+func NewTracer(exporter SpanExporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		queue:    make(chan *Span, tracerQueueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span named name as a child of the span in ctx, or as the
+// root of a new trace, and returns a context carrying it. Attributes are
+// given as key-value pairs.
+func (t *Tracer) Start(ctx context.Context, name string, kind int, attributes ...any) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]any),
+		tracer:     t,
+	}
+	if parent, ok := SpanContextFromContext(ctx); ok && parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.ParentSpanID = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = true
+	}
+	rand.Read(span.Context.SpanID[:])
+	span.SetAttributes(attributes...)
+
+	return ContextWithSpanContext(ctx, span.Context), span
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- span:
+	default:
+	}
+}
+
+// run exports batches of finished spans until the queue is closed.
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(tracerFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.ExportSpans(context.Background(), batch); err != nil {
+			slog.Warn("failed to export spans", "spans", len(batch), "error", err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case span, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= tracerBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown exports the spans finished so far and shuts down the exporter.
+// Spans finished afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tracing exporters.
+const (
+	TracingNone   = "none"
+	TracingStdout = "stdout"
+	TracingFile   = "file"
+	TracingOTLP   = "otlp"
+)
+
+// tracingScope names the instrumentation scope of the engine's spans.
+const tracingScope = "myworkflow"
+
+// The OTLP/JSON encoding of an ExportTraceServiceRequest. IDs are hex
+// strings and 64-bit integers are decimal strings, as the encoding requires.
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// OTLP status codes.
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func otlpAttribute(key string, value any) otlpKeyValue {
+	var v otlpValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case bool:
+		v.BoolValue = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// encodeOTLP encodes spans as an OTLP/JSON export request from the given
+// service.
+func encodeOTLP(serviceName string, spans []*Span) ([]byte, error) {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: otlpTime(span.Start),
+			EndTimeUnixNano:   otlpTime(span.End),
+			Status:            otlpStatus{Code: otlpStatusUnset},
+		}
+		if span.ParentSpanID != (SpanID{}) {
+			s.ParentSpanID = span.ParentSpanID.String()
+		}
+		for _, key := range sortedKeys(span.Attributes) {
+			s.Attributes = append(s.Attributes, otlpAttribute(key, span.Attributes[key]))
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		encoded = append(encoded, s)
+	}
+
+	return json.Marshal(otlpTraceRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{otlpAttribute("service.name", serviceName)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: tracingScope}, Spans: encoded}},
+	}}})
+}
+
+// WriterExporter writes each batch of spans as a line of OTLP/JSON, the
+// format read by the OpenTelemetry Collector's otlpjsonfile receiver.
+type WriterExporter struct {
+	serviceName string
+	w           io.Writer
+	closer      io.Closer
+	mu          sync.Mutex
+}
+
+// NewWriterExporter creates an exporter writing to w, such as os.Stdout.
+func NewWriterExporter(serviceName string, w io.Writer) *WriterExporter {
+	return &WriterExporter{serviceName: serviceName, w: w}
+}
+
+// NewFileExporter creates an exporter appending to a file, which is closed
+// on shutdown.
+func NewFileExporter(serviceName, path string) (*WriterExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	return &WriterExporter{serviceName: serviceName, w: file, closer: file}, nil
+}
+
+// ExportSpans writes a batch of spans
+func (e *WriterExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	data, err := encodeOTLP(e.serviceName, spans)
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
+
+// Shutdown closes the file written by a file exporter
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// otlpExportTimeout bounds each export request.
+const otlpExportTimeout = 10 * time.Second
+
+// OTLPExporter sends spans to an OTLP/HTTP endpoint, such as
+// http://localhost:4318/v1/traces, encoded as JSON.
+type OTLPExporter struct {
+	serviceName string
+	endpoint    string
+	// Client sends the export requests; it can be replaced before use.
+	Client *http.Client
+}
+
+// NewOTLPExporter creates an exporter posting to the given endpoint.
+func NewOTLPExporter(serviceName, endpoint string) *OTLPExporter {
+	return &OTLPExporter{
+		serviceName: serviceName,
+		endpoint:    endpoint,
+		Client:      &http.Client{Timeout: otlpExportTimeout},
+	}
+}
+
+// ExportSpans posts a batch of spans
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	data, err := encodeOTLP(e.serviceName, spans)
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create export request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to export spans: %s", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown does nothing; requests are sent as spans are exported
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
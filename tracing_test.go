@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	tests := []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	}
+	for _, value := range tests {
+		sc, err := ParseTraceparent(value)
+		if err != nil {
+			t.Fatalf("ParseTraceparent(%q): %v", value, err)
+		}
+		if got := sc.Traceparent(); got != value {
+			t.Errorf("Traceparent() = %q, want %q", got, value)
+		}
+	}
+
+	sc, _ := ParseTraceparent(tests[0])
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Errorf("ParseTraceparent(%q) = %+v", tests[0], sc)
+	}
+
+	// A later version may add fields, which are ignored.
+	sc, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	if err != nil {
+		t.Fatalf("ParseTraceparent of a later version: %v", err)
+	}
+	if got := sc.Traceparent(); got != tests[0] {
+		t.Errorf("Traceparent() = %q, want %q", got, tests[0])
+	}
+}
+
+func TestParseTraceparentInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+	}
+	for _, value := range tests {
+		if sc, err := ParseTraceparent(value); err == nil {
+			t.Errorf("ParseTraceparent(%q) = %+v, want an error", value, sc)
+		}
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	start := time.Unix(1700000000, 0)
+	span := &Span{
+		Name:         "workflow Onboarding",
+		Kind:         SpanKindInternal,
+		Context:      SpanContext{TraceID: parent.TraceID, SpanID: SpanID{1, 2, 3, 4, 5, 6, 7, 8}, Sampled: true},
+		ParentSpanID: parent.SpanID,
+		Start:        start,
+		End:          start.Add(time.Second),
+		Attributes:   map[string]any{"workflow.name": "Onboarding", "workflow.steps": 3, "workflow.cached": true},
+	}
+	span.RecordError(errors.New("rule failed"))
+
+	var buf bytes.Buffer
+	exporter := NewWriterExporter("myworkflow-test", &buf)
+	if err := exporter.ExportSpans(context.Background(), []*Span{span}); err != nil {
+		t.Fatalf("ExportSpans: %v", err)
+	}
+	if err := exporter.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte("}\n")) || bytes.Count(buf.Bytes(), []byte("\n")) != 1 {
+		t.Fatalf("export is not a single line of JSON: %q", buf.String())
+	}
+
+	// Decoded as generic JSON so that the field names are checked too.
+	type attribute struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []attribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Scope struct {
+					Name string `json:"name"`
+				} `json:"scope"`
+				Spans []map[string]any `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &request); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 ||
+		len(request.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("unexpected request: %s", buf.String())
+	}
+
+	resource := request.ResourceSpans[0].Resource
+	if len(resource.Attributes) != 1 || resource.Attributes[0].Key != "service.name" ||
+		resource.Attributes[0].Value["stringValue"] != "myworkflow-test" {
+		t.Errorf("resource attributes = %+v", resource.Attributes)
+	}
+	if scope := request.ResourceSpans[0].ScopeSpans[0].Scope.Name; scope != tracingScope {
+		t.Errorf("scope = %q, want %q", scope, tracingScope)
+	}
+
+	got := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	want := map[string]any{
+		"traceId":           "4bf92f3577b34da6a3ce929d0e0e4736",
+		"spanId":            "0102030405060708",
+		"parentSpanId":      "00f067aa0ba902b7",
+		"name":              "workflow Onboarding",
+		"kind":              float64(SpanKindInternal),
+		"startTimeUnixNano": strconv.FormatInt(start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(start.Add(time.Second).UnixNano(), 10),
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("span %s = %v, want %v", key, got[key], value)
+		}
+	}
+	status, _ := got["status"].(map[string]any)
+	if status["code"] != float64(otlpStatusError) || status["message"] != "rule failed" {
+		t.Errorf("span status = %v", got["status"])
+	}
+
+	// Attributes are sorted by key, with ints encoded as strings.
+	wantAttributes := []string{
+		`{"key":"workflow.cached","value":{"boolValue":true}}`,
+		`{"key":"workflow.name","value":{"stringValue":"Onboarding"}}`,
+		`{"key":"workflow.steps","value":{"intValue":"3"}}`,
+	}
+	attributes, _ := got["attributes"].([]any)
+	if len(attributes) != len(wantAttributes) {
+		t.Fatalf("span attributes = %v", got["attributes"])
+	}
+	for i, attribute := range attributes {
+		encoded, _ := json.Marshal(attribute)
+		if string(encoded) != wantAttributes[i] {
+			t.Errorf("attribute %d = %s, want %s", i, encoded, wantAttributes[i])
+		}
+	}
+}
+
+func TestWriterExporterRootSpan(t *testing.T) {
+	var buf bytes.Buffer
+	span := &Span{
+		Name:    "GET /api/workflows",
+		Kind:    SpanKindServer,
+		Context: SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true},
+	}
+	if err := NewWriterExporter("myworkflow-test", &buf).ExportSpans(context.Background(), []*Span{span}); err != nil {
+		t.Fatalf("ExportSpans: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("parentSpanId")) || bytes.Contains(buf.Bytes(), []byte(`"attributes":null`)) {
+		t.Errorf("root span has a parent or null attributes: %s", buf.String())
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"status":{"code":0}`)) {
+		t.Errorf("root span status is not unset: %s", buf.String())
+	}
+}
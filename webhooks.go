@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Webhook event types.
+const (
+	WebhookWorkflowStarted   = "workflow.started"
+	WebhookWorkflowCompleted = "workflow.completed"
+	WebhookWorkflowFailed    = "workflow.failed"
+	WebhookStepTransition    = "step.transition"
+)
+
+var webhookEventTypes = []string{WebhookWorkflowStarted, WebhookWorkflowCompleted, WebhookWorkflowFailed, WebhookStepTransition}
+
+// Webhook headers. The signature is the hex HMAC-SHA256 of the request
+// body keyed with the webhook's secret, prefixed with "sha256=".
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookIDHeader        = "X-Webhook-Id"
+)
+
+// Webhook is a URL that receives workflow events. Workflows and Events
+// restrict the events sent; when empty, every workflow or event type is
+// sent.
+type Webhook struct {
+	URL       string   `yaml:"url" json:"url"`
+	Secret    string   `yaml:"secret,omitempty" json:"secret,omitempty"`
+	Workflows []string `yaml:"workflows,omitempty" json:"workflows,omitempty"`
+	Events    []string `yaml:"events,omitempty" json:"events,omitempty"`
+}
+
+// wants reports whether the webhook receives an event of a workflow.
+func (w *Webhook) wants(eventType, workflowName string) bool {
+	return (len(w.Events) == 0 || slices.Contains(w.Events, eventType)) &&
+		(len(w.Workflows) == 0 || slices.Contains(w.Workflows, workflowName))
+}
+
+// LoadWebhooks reads webhooks from a YAML file listing them under the
+// webhooks key.
+func LoadWebhooks(path string) ([]Webhook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks file: %w", err)
+	}
+
+	var file struct {
+		Webhooks []Webhook `yaml:"webhooks"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid webhooks file %s: %w", path, err)
+	}
+
+	for i, webhook := range file.Webhooks {
+		if u, err := url.Parse(webhook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhooks file %s: webhook %d: url must be an http or https URL", path, i+1)
+		}
+		for _, event := range webhook.Events {
+			if !slices.Contains(webhookEventTypes, event) {
+				return nil, fmt.Errorf("invalid webhooks file %s: webhook %d: unknown event '%s'", path, i+1, event)
+			}
+		}
+	}
+	return file.Webhooks, nil
+}
+
+// WebhookEvent is the JSON body posted to a webhook. Data is a copy of the
+// instance data when the event happened.
+type WebhookEvent struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	Workflow   string         `json:"workflow"`
+	InstanceID string         `json:"instance_id"`
+	Timestamp  time.Time      `json:"timestamp"`
+	FromStep   string         `json:"from,omitempty"`
+	ToStep     string         `json:"to,omitempty"`
+	Step       string         `json:"step,omitempty"`
+	Status     string         `json:"status,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Data       map[string]any `json:"data"`
+}
+
+// WebhookDelivery is a line of the delivery log, recording one attempt to
+// deliver an event to a webhook.
+type WebhookDelivery struct {
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Workflow   string    `json:"workflow"`
+	InstanceID string    `json:"instance_id"`
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"`
+	Timestamp  time.Time `json:"timestamp"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// Default delivery settings of a WebhookHandler.
+const (
+	webhookMaxAttempts = 5
+	webhookBackoff     = time.Second
+	webhookMaxBackoff  = time.Minute
+	webhookTimeout     = 10 * time.Second
+)
+
+// WebhookHandler implements AsyncHandler and FailureHandler to post
+// workflow events to webhooks, so the engine's outbox delivers them in the
+// background. Failed deliveries are retried with exponential backoff,
+// doubling from Backoff up to MaxBackoff, for up to MaxAttempts attempts;
+// responses with a 4xx status other than 408 and 429 are not retried. An
+// event that still fails is returned as an error for the outbox to retry
+// later, when it is sent again to every webhook that wants it. Every
+// attempt is appended to the delivery log.
+type WebhookHandler struct {
+	webhooks []Webhook
+	log      io.WriteCloser
+	logMu    sync.Mutex
+
+	// Client sends the requests; it and the delivery settings can be
+	// replaced before the handler is used.
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// NewWebhookHandler creates a handler posting to the given webhooks and
+// appending its delivery log to logPath; an empty path keeps no log.
+func NewWebhookHandler(webhooks []Webhook, logPath string) (*WebhookHandler, error) {
+	h := &WebhookHandler{
+		webhooks:    webhooks,
+		Client:      &http.Client{Timeout: webhookTimeout},
+		MaxAttempts: webhookMaxAttempts,
+		Backoff:     webhookBackoff,
+		MaxBackoff:  webhookMaxBackoff,
+	}
+	if logPath != "" {
+		file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open webhook delivery log: %w", err)
+		}
+		h.log = file
+	}
+	return h, nil
+}
+
+// HandlerName names the handler's events in the outbox
+func (h *WebhookHandler) HandlerName() string {
+	return "webhooks"
+}
+
+// OnWorkflowStart sends a workflow.started event
+func (h *WebhookHandler) OnWorkflowStart(ctx context.Context, workflowName string, state *WorkflowState) error {
+	return h.send(ctx, newWebhookEvent(WebhookWorkflowStarted, workflowName, state))
+}
+
+// OnWorkflowEnd sends a workflow.completed event
+func (h *WebhookHandler) OnWorkflowEnd(ctx context.Context, workflowName string, state *WorkflowState) error {
+	return h.send(ctx, newWebhookEvent(WebhookWorkflowCompleted, workflowName, state))
+}
+
+// OnStepTransition sends a step.transition event
+func (h *WebhookHandler) OnStepTransition(ctx context.Context, workflowName string, fromStep, toStep string, state *WorkflowState) error {
+	event := newWebhookEvent(WebhookStepTransition, workflowName, state)
+	event.FromStep, event.ToStep = fromStep, toStep
+	return h.send(ctx, event)
+}
+
+// OnWorkflowFailed sends a workflow.failed event
+func (h *WebhookHandler) OnWorkflowFailed(ctx context.Context, workflowName string, state *WorkflowState, err error) error {
+	return h.send(ctx, newWebhookEvent(WebhookWorkflowFailed, workflowName, state))
+}
+
+// newWebhookEvent creates an event from an instance's state. Its ID is
+// derived from the instance, the event type and the number of steps taken,
+// so an event delivered again keeps its ID and receivers can recognize
+// duplicates.
+func newWebhookEvent(eventType, workflowName string, state *WorkflowState) *WebhookEvent {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%s\x00%d", state.ID, eventType, len(state.Path)))
+	return &WebhookEvent{
+		ID:         hex.EncodeToString(sum[:8]),
+		Type:       eventType,
+		Workflow:   workflowName,
+		InstanceID: state.ID,
+		Timestamp:  webhookEventTime(eventType, state),
+		Step:       state.CurrentStep,
+		Status:     state.Status,
+		Error:      state.Error,
+		Data:       CopyData(state.Data),
+	}
+}
+
+// webhookEventTime returns when an event happened, which may be well before
+// it is delivered.
+func webhookEventTime(eventType string, state *WorkflowState) time.Time {
+	t := time.Now()
+	switch eventType {
+	case WebhookWorkflowStarted:
+		if !state.StartedAt.IsZero() {
+			t = state.StartedAt
+		}
+	case WebhookWorkflowCompleted, WebhookWorkflowFailed:
+		if !state.FinishedAt.IsZero() {
+			t = state.FinishedAt
+		}
+	case WebhookStepTransition:
+		if n := len(state.Trace); n > 0 {
+			entry := state.Trace[n-1]
+			t = entry.StartedAt.Add(time.Duration(entry.DurationMS * float64(time.Millisecond)))
+		}
+	}
+	return t.UTC()
+}
+
+// send delivers an event to every webhook that wants it, returning the
+// errors of the deliveries that failed.
+func (h *WebhookHandler) send(ctx context.Context, event *WebhookEvent) error {
+	var errs []error
+	for i := range h.webhooks {
+		webhook := &h.webhooks[i]
+		if !webhook.wants(event.Type, event.Workflow) {
+			continue
+		}
+		if err := h.Deliver(ctx, webhook, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", webhook.URL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Deliver posts an event to a webhook, retrying until it is accepted, it is
+// rejected with a status that isn't worth retrying, the attempts run out or
+// ctx is done.
+func (h *WebhookHandler) Deliver(ctx context.Context, webhook *Webhook, event *WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+
+	backoff := h.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := h.attempt(ctx, webhook, event, body, attempt)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= h.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(2*backoff, h.MaxBackoff)
+	}
+}
+
+// attempt posts an event once and logs the outcome. It reports whether a
+// failed attempt is worth retrying.
+func (h *WebhookHandler) attempt(ctx context.Context, webhook *Webhook, event *WebhookEvent, body []byte, attempt int) (retry bool, err error) {
+	delivery := WebhookDelivery{
+		EventID:    event.ID,
+		EventType:  event.Type,
+		Workflow:   event.Workflow,
+		InstanceID: event.InstanceID,
+		URL:        webhook.URL,
+		Attempt:    attempt,
+		Timestamp:  time.Now().UTC(),
+	}
+	defer func() {
+		delivery.DurationMS = float64(time.Since(delivery.Timestamp)) / float64(time.Millisecond)
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		delivery.Delivered = err == nil
+		h.logDelivery(delivery)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event.Type)
+	req.Header.Set(WebhookIDHeader, event.ID)
+	if webhook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, body))
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook responded %s", resp.Status)
+}
+
+// logDelivery appends a delivery to the log.
+func (h *WebhookHandler) logDelivery(delivery WebhookDelivery) {
+	if h.log == nil {
+		return
+	}
+	line, err := json.Marshal(delivery)
+	if err != nil {
+		return
+	}
+
+	h.logMu.Lock()
+	defer h.logMu.Unlock()
+	h.log.Write(append(line, '\n'))
+}
+
+// Close closes the delivery log. The outbox delivering the handler's events
+// must be stopped first.
+func (h *WebhookHandler) Close() error {
+	if h.log == nil {
+		return nil
+	}
+	return h.log.Close()
+}
+
+// SignWebhook returns the signature header value for a webhook body:
+// "sha256=" followed by the hex HMAC-SHA256 of the body keyed with secret.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether a signature header value matches a body,
+// as a webhook receiver would check it.
+func VerifyWebhook(secret string, body []byte, signature string) bool {
+	expected := SignWebhook(secret, body)
+	return hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature)))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer records the requests it receives and answers them with the
+// given statuses in turn, repeating the last one.
+type webhookServer struct {
+	*httptest.Server
+	statuses []int
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		n := len(s.requests)
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		s.mu.Unlock()
+		w.WriteHeader(s.statuses[min(n, len(s.statuses)-1)])
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func newTestWebhookHandler(t *testing.T, webhooks ...Webhook) (*WebhookHandler, string) {
+	t.Helper()
+	logPath := filepath.Join(t.TempDir(), "deliveries.jsonl")
+	h, err := NewWebhookHandler(webhooks, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Backoff = time.Millisecond
+	h.MaxBackoff = time.Millisecond
+	t.Cleanup(func() { h.Close() })
+	return h, logPath
+}
+
+func testWebhookState() *WorkflowState {
+	return &WorkflowState{
+		ID:          "instance-1",
+		CurrentStep: "end",
+		Status:      StatusCompleted,
+		Path:        []string{"start", "end"},
+		Data:        map[string]any{"name": "Sam"},
+	}
+}
+
+func readDeliveries(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		lines++
+	}
+	return lines
+}
+
+func TestWebhookSignature(t *testing.T) {
+	server := newWebhookServer(t, http.StatusNoContent)
+	h, _ := newTestWebhookHandler(t, Webhook{URL: server.URL, Secret: "s3cret"})
+
+	if err := h.OnWorkflowEnd(context.Background(), "Onboarding", testWebhookState()); err != nil {
+		t.Fatalf("OnWorkflowEnd: %v", err)
+	}
+	if server.count() != 1 {
+		t.Fatalf("got %d requests, want 1", server.count())
+	}
+
+	req, body := server.requests[0], server.bodies[0]
+	if got, want := req.Header.Get(WebhookSignatureHeader), SignWebhook("s3cret", body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if !VerifyWebhook("s3cret", body, req.Header.Get(WebhookSignatureHeader)) {
+		t.Error("VerifyWebhook rejected the signature")
+	}
+	if VerifyWebhook("other", body, req.Header.Get(WebhookSignatureHeader)) {
+		t.Error("VerifyWebhook accepted a signature made with another secret")
+	}
+	if got := req.Header.Get(WebhookEventHeader); got != WebhookWorkflowCompleted {
+		t.Errorf("event header = %q, want %q", got, WebhookWorkflowCompleted)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		want     int
+	}{
+		{"server errors retried until accepted", []int{500, 503, 204}, false, 3},
+		{"too many requests retried", []int{429, 200}, false, 2},
+		{"server errors retried up to MaxAttempts", []int{500}, true, 4},
+		{"client errors not retried", []int{400}, true, 1},
+		{"not found not retried", []int{404}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newWebhookServer(t, tt.statuses...)
+			h, logPath := newTestWebhookHandler(t, Webhook{URL: server.URL})
+			h.MaxAttempts = 4
+
+			err := h.OnWorkflowStart(context.Background(), "Onboarding", testWebhookState())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if server.count() != tt.want {
+				t.Errorf("got %d attempts, want %d", server.count(), tt.want)
+			}
+			if lines := readDeliveries(t, logPath); lines != tt.want {
+				t.Errorf("delivery log has %d lines, want %d", lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookFilters(t *testing.T) {
+	server := newWebhookServer(t, http.StatusOK)
+	h, _ := newTestWebhookHandler(t, Webhook{
+		URL:       server.URL,
+		Workflows: []string{"Onboarding"},
+		Events:    []string{WebhookWorkflowFailed},
+	})
+
+	ctx := context.Background()
+	state := testWebhookState()
+	h.OnWorkflowStart(ctx, "Onboarding", state)
+	h.OnStepTransition(ctx, "Onboarding", "start", "end", state)
+	h.OnWorkflowFailed(ctx, "Billing", state, io.ErrUnexpectedEOF)
+	if server.count() != 0 {
+		t.Fatalf("got %d requests for unwanted events, want 0", server.count())
+	}
+
+	h.OnWorkflowFailed(ctx, "Onboarding", state, io.ErrUnexpectedEOF)
+	if server.count() != 1 {
+		t.Fatalf("got %d requests, want 1", server.count())
+	}
+	if got := server.requests[0].Header.Get(WebhookEventHeader); got != WebhookWorkflowFailed {
+		t.Errorf("event header = %q, want %q", got, WebhookWorkflowFailed)
+	}
+}
@@ -1,25 +1,173 @@
 package main
 
+import (
+	"fmt"
+	"time"
+)
+
 // Workflow represents a sequence of steps.
 type Workflow struct {
-	Name        string       `json:"name" yaml:"name"`
-	Description string       `json:"description" yaml:"description"`
-	StartStep   string       `json:"start_step" yaml:"start_step"`
-	Transitions []Transition `json:"transitions" yaml:"transitions"`
+	Name        string            `json:"name" yaml:"name"`
+	Description string            `json:"description" yaml:"description"`
+	StartStep   string            `json:"start_step" yaml:"start_step"`
+	DataSchema  map[string]string `json:"data_schema,omitempty" yaml:"data_schema,omitempty"`
+	InputSchema *InputSchema      `json:"input_schema,omitempty" yaml:"input_schema,omitempty"`
+	Steps       []Step            `json:"steps,omitempty" yaml:"steps,omitempty"`
+	Transitions []Transition      `json:"transitions" yaml:"transitions"`
+}
+
+// ExprSchema returns the workflow's data schema for type-checking expression
+// rules, or nil if the workflow does not declare one.
+func (w *Workflow) ExprSchema() (ExprSchema, error) {
+	if len(w.DataSchema) == 0 {
+		return nil, nil
+	}
+
+	schema := make(ExprSchema, len(w.DataSchema))
+	for field, typeName := range w.DataSchema {
+		t, err := ParseExprType(typeName)
+		if err != nil {
+			return nil, fmt.Errorf("data_schema field '%s': %w", field, err)
+		}
+		schema[field] = t
+	}
+	return schema, nil
+}
+
+// ValidateInput checks data against the workflow's input schema. It returns
+// a *ValidationError listing every invalid field, or nil if the data is valid
+// or the workflow declares no input schema.
+func (w *Workflow) ValidateInput(data map[string]any) error {
+	if w.InputSchema == nil {
+		return nil
+	}
+	if fields := w.InputSchema.Validate(data); len(fields) > 0 {
+		return &ValidationError{Workflow: w.Name, Fields: fields}
+	}
+	return nil
+}
+
+// Step declares the actions to run when an instance enters or leaves a step.
+// Steps without actions need not be declared.
+type Step struct {
+	Name    string   `json:"name" yaml:"name"`
+	OnEnter []string `json:"on_enter,omitempty" yaml:"on_enter,omitempty"`
+	OnExit  []string `json:"on_exit,omitempty" yaml:"on_exit,omitempty"`
+}
+
+// Step returns the declaration of the named step, or nil if it has none.
+func (w *Workflow) Step(name string) *Step {
+	for i := range w.Steps {
+		if w.Steps[i].Name == name {
+			return &w.Steps[i]
+		}
+	}
+	return nil
 }
 
 // Transition defines a move from one step to another based on a rule.
 type Transition struct {
-	FromStep     string `json:"from" yaml:"from"`
-	ToStep       string `json:"to" yaml:"to"`
-	RuleName     string `json:"rule" yaml:"rule"`
-	FallbackStep string `json:"fallback_to" yaml:"fallback_to"`
+	FromStep     string   `json:"from" yaml:"from"`
+	ToStep       string   `json:"to" yaml:"to"`
+	Rule         RuleSpec `json:"rule" yaml:"rule"`
+	FallbackStep string   `json:"fallback_to" yaml:"fallback_to"`
+	// Mutates opts the transition's rules into modifying workflow data.
+	Mutates bool `json:"mutates,omitempty" yaml:"mutates,omitempty"`
 }
 
 // WorkflowState represents the current state of a workflow instance.
 type WorkflowState struct {
+	// ID identifies the instance; the engine assigns one to a new instance.
+	ID string `json:"id,omitempty"`
+	// Workflow names the workflow the instance runs; the engine sets it.
+	Workflow    string         `json:"workflow,omitempty"`
 	CurrentStep string         `json:"current_step"`
 	Data        map[string]any `json:"data"`
+	Status      string         `json:"status,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	// Path lists the steps the instance has visited, in order.
+	Path []string `json:"path,omitempty"`
+	// StartedAt and FinishedAt are set by the engine when the instance
+	// starts and when it completes or fails.
+	StartedAt      time.Time         `json:"started_at,omitzero"`
+	FinishedAt     time.Time         `json:"finished_at,omitzero"`
+	LastTransition *TransitionRecord `json:"last_transition,omitempty"`
+	// Trace lists every transition evaluated by the instance, in order.
+	Trace []TraceEntry `json:"trace,omitempty"`
+}
+
+// Workflow instance statuses.
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// TransitionRecord describes the most recent transition taken by an instance.
+type TransitionRecord struct {
+	FromStep   string      `json:"from"`
+	ToStep     string      `json:"to"`
+	RuleName   string      `json:"rule"`
+	RuleResult bool        `json:"rule_result"`
+	RuleTrace  *RuleResult `json:"rule_trace,omitempty"`
+	Diff       DataDiff    `json:"diff,omitempty"`
+}
+
+// TraceEntry records one transition evaluated by an instance: the rule
+// results, the data the rules were given and, if evaluating the rule or
+// running the step actions failed, the error. Duration covers the rule
+// evaluation and the on_exit and on_enter actions of the transition.
+type TraceEntry struct {
+	FromStep   string         `json:"from"`
+	ToStep     string         `json:"to,omitempty"`
+	RuleName   string         `json:"rule"`
+	RuleResult bool           `json:"rule_result"`
+	RuleTrace  *RuleResult    `json:"rule_trace,omitempty"`
+	Input      map[string]any `json:"input"`
+	Diff       DataDiff       `json:"diff,omitempty"`
+	StartedAt  time.Time      `json:"started_at"`
+	DurationMS float64        `json:"duration_ms"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// addTrace completes a trace entry with its duration and error and appends
+// it to the trace.
+func (s *WorkflowState) addTrace(entry TraceEntry, err error) {
+	entry.DurationMS = float64(time.Since(entry.StartedAt)) / float64(time.Millisecond)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	s.Trace = append(s.Trace, entry)
+}
+
+// snapshot returns a copy of the state that isn't changed as the instance
+// carries on running.
+func (s *WorkflowState) snapshot() *WorkflowState {
+	c := *s
+	c.Data = CopyData(s.Data)
+	c.Path = append([]string(nil), s.Path...)
+	c.Trace = append([]TraceEntry(nil), s.Trace...)
+	return &c
+}
+
+// RuleResult records the outcome of evaluating a rule spec. Combinations
+// hold the results of the rules they evaluated, in order; rules skipped by
+// short-circuit evaluation are left out.
+type RuleResult struct {
+	Rule     string       `json:"rule"`
+	Result   bool         `json:"result"`
+	Children []RuleResult `json:"children,omitempty"`
+}
+
+// DataDiff maps a data key to the change a rule made to it.
+type DataDiff map[string]DataChange
+
+// DataChange holds the old and new value of a single data key. Removed is
+// set, and New is nil, when the key was removed.
+type DataChange struct {
+	Old     any  `json:"old"`
+	New     any  `json:"new"`
+	Removed bool `json:"removed,omitempty"`
 }
 
 // Rule represents a business rule
@@ -27,5 +175,6 @@ type Rule struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Language    string `json:"language"`
+	Format      string `json:"format,omitempty"`
 	Content     string `json:"content"`
 }